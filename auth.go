@@ -0,0 +1,379 @@
+package core
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const principalKey = "principal"
+
+// Principal the caller authenticated by BasicAuth, APIKey, HMACAuth or JWT
+type Principal struct {
+	ID     string
+	Scheme string // basic, api_key, hmac or bearer
+	Roles  []string
+}
+
+// SetPrincipal record the authenticated caller of the request
+func (c *Ctx) SetPrincipal(p *Principal) {
+	c.Set(principalKey, p)
+}
+
+// Principal returns the authenticated caller, nil for anonymous requests
+func (c *Ctx) Principal() *Principal {
+	p, _ := c.Get(principalKey)
+	principal, _ := p.(*Principal)
+	return principal
+}
+
+// unauthorized abort the request with 401 and the challenge of the scheme
+func unauthorized(c *Ctx, challenge string) {
+	c.SetHeader(HeaderWWWAuthenticate, challenge)
+	c.Abort().SendStatus(ErrUnauthorized.Code, ErrUnauthorized.Message)
+}
+
+// secureCompare compare the digests so the time depends on neither the
+// content nor the length
+func secureCompare(a, b string) bool {
+	x, y := sha256.Sum256([]byte(a)), sha256.Sum256([]byte(b))
+	return subtle.ConstantTimeCompare(x[:], y[:]) == 1
+}
+
+// UserStore returns the password of a user of BasicAuth
+type UserStore interface {
+	Password(user string) (password string, ok bool)
+}
+
+// Users a UserStore of the configured users
+type Users map[string]string
+
+func (u Users) Password(user string) (string, bool) {
+	p, ok := u[user]
+	return p, ok
+}
+
+// BasicAuthConfig options of the BasicAuth middleware
+//
+//	basic_auth:
+//	  realm: Restricted
+//	  users:
+//	    ann: "s3cr3t"
+type BasicAuthConfig struct {
+	Realm string
+	Users UserStore
+}
+
+// NewBasicAuthConfig returns the BasicAuthConfig of the basic_auth section
+func NewBasicAuthConfig(conf Options) BasicAuthConfig {
+	users := Users{}
+	for user, pass := range conf.GetMap("users") {
+		users[user] = fmt.Sprint(pass)
+	}
+	return BasicAuthConfig{Realm: conf.GetString("realm", "Restricted"), Users: users}
+}
+
+// BasicAuth middleware authenticate the request by the Basic scheme against
+// the user store. Without conf it is configured by the basic_auth section.
+func BasicAuth(conf ...BasicAuthConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  BasicAuthConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewBasicAuthConfig(c.Core().Conf.GetMap("basic_auth"))
+			}
+			if cfg.Realm == "" {
+				cfg.Realm = "Restricted"
+			}
+			if cfg.Users == nil {
+				cfg.Users = Users{}
+			}
+		})
+		user, pass, ok := c.R.BasicAuth()
+		if ok {
+			want, known := cfg.Users.Password(user)
+			// unknown users compare too, their time is the one of a wrong password
+			if secureCompare(pass, want) && known {
+				c.SetPrincipal(&Principal{ID: user, Scheme: "basic"})
+				return
+			}
+		}
+		unauthorized(c, `Basic realm="`+strings.ReplaceAll(cfg.Realm, `"`, "")+`", charset="UTF-8"`)
+	}
+}
+
+// APIKeyValidator returns the principal of an api key, nil for unknown keys
+type APIKeyValidator func(c *Ctx, key string) (*Principal, error)
+
+// APIKeyConfig options of the APIKey middleware
+//
+//	api_key:
+//	  header: X-API-Key      # default X-API-Key
+//	  query: api_key         # also read from the query, off by default
+//	  keys:                  # key: name of the caller
+//	    "9f8e...": billing
+type APIKeyConfig struct {
+	Header   string
+	Query    string
+	Keys     map[string]string
+	Validate APIKeyValidator // used instead of Keys when set
+}
+
+// NewAPIKeyConfig returns the APIKeyConfig of the api_key section
+func NewAPIKeyConfig(conf Options) APIKeyConfig {
+	keys := map[string]string{}
+	for key, name := range conf.GetMap("keys") {
+		keys[key] = fmt.Sprint(name)
+	}
+	return APIKeyConfig{
+		Header: conf.GetString("header", "X-API-Key"),
+		Query:  conf.GetString("query"),
+		Keys:   keys,
+	}
+}
+
+// APIKey middleware authenticate the request by an api key of the header
+// or the query. Without conf it is configured by the api_key section.
+func APIKey(conf ...APIKeyConfig) HandlerFunc {
+	var (
+		once     sync.Once
+		cfg      APIKeyConfig
+		validate APIKeyValidator
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewAPIKeyConfig(c.Core().Conf.GetMap("api_key"))
+			}
+			if cfg.Header == "" {
+				cfg.Header = "X-API-Key"
+			}
+			if validate = cfg.Validate; validate == nil {
+				validate = staticAPIKeys(cfg.Keys)
+			}
+		})
+		key := c.GetHeader(cfg.Header)
+		if key == "" && cfg.Query != "" {
+			key = c.R.URL.Query().Get(cfg.Query)
+		}
+		if key != "" {
+			p, err := validate(c, key)
+			if err != nil {
+				Warn("api key: %s", err)
+			} else if p != nil {
+				if p.Scheme == "" {
+					p.Scheme = "api_key"
+				}
+				c.SetPrincipal(p)
+				return
+			}
+		}
+		unauthorized(c, `APIKey header="`+cfg.Header+`"`)
+	}
+}
+
+// staticAPIKeys validate the keys by their digest, the lookup time does not
+// depend on how much of a key matches
+func staticAPIKeys(keys map[string]string) APIKeyValidator {
+	digests := make(map[[32]byte]string, len(keys))
+	for key, name := range keys {
+		digests[sha256.Sum256([]byte(key))] = name
+	}
+	return func(c *Ctx, key string) (*Principal, error) {
+		if name, ok := digests[sha256.Sum256([]byte(key))]; ok {
+			return &Principal{ID: name, Scheme: "api_key"}, nil
+		}
+		return nil, nil
+	}
+}
+
+// HMACScheme scheme of the Authorization header of signed requests
+const HMACScheme = "HMAC-SHA256"
+
+// HMACAuthConfig options of the HMACAuth middleware
+//
+//	hmac_auth:
+//	  window: 5m            # accepted clock skew of the Date, default 5m
+//	  max_body: 1048576     # signed bodies are read in memory, larger ones get 413
+//	  keys:                 # key id: secret
+//	    github: "webhook secret"
+type HMACAuthConfig struct {
+	Keys    map[string]string
+	Secret  func(keyID string) ([]byte, bool) // used instead of Keys when set
+	Window  time.Duration
+	MaxBody int64
+}
+
+// NewHMACAuthConfig returns the HMACAuthConfig of the hmac_auth section
+func NewHMACAuthConfig(conf Options) HMACAuthConfig {
+	keys := map[string]string{}
+	for id, secret := range conf.GetMap("keys") {
+		keys[id] = fmt.Sprint(secret)
+	}
+	return HMACAuthConfig{
+		Keys:    keys,
+		Window:  conf.GetDuration("window", 5*time.Minute),
+		MaxBody: conf.GetInt64("max_body", 1<<20),
+	}
+}
+
+// HMACAuth middleware authenticate requests signed by SignRequest. The
+// signature covers the method, the request uri, the Date and the digest of
+// the body:
+//
+//	Authorization: HMAC-SHA256 keyId="github", signature="base64"
+//
+// Requests whose Date is out of the window are refused, so is a signature
+// seen before within it. A body over MaxBody gets 413. Without conf it is configured by the hmac_auth
+// section.
+func HMACAuth(conf ...HMACAuthConfig) HandlerFunc {
+	var (
+		once   sync.Once
+		cfg    HMACAuthConfig
+		secret func(string) ([]byte, bool)
+		seen   = &replayCache{seen: map[string]time.Time{}}
+	)
+	challenge := HMACScheme + ` headers="(request-target) date digest"`
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewHMACAuthConfig(c.Core().Conf.GetMap("hmac_auth"))
+			}
+			if cfg.Window <= 0 {
+				cfg.Window = 5 * time.Minute
+			}
+			if cfg.MaxBody <= 0 {
+				cfg.MaxBody = 1 << 20
+			}
+			if secret = cfg.Secret; secret == nil {
+				secret = func(id string) ([]byte, bool) {
+					s, ok := cfg.Keys[id]
+					return []byte(s), ok
+				}
+			}
+		})
+		keyID, sig, ok := parseHMACAuthorization(c.GetHeader(HeaderAuthorization))
+		if !ok {
+			unauthorized(c, challenge)
+			return
+		}
+		date, err := http.ParseTime(c.GetHeader(HeaderDate))
+		if err != nil || time.Since(date) > cfg.Window || time.Until(date) > cfg.Window {
+			unauthorized(c, challenge)
+			return
+		}
+		key, ok := secret(keyID)
+		if !ok {
+			unauthorized(c, challenge)
+			return
+		}
+		body, err := io.ReadAll(io.LimitReader(c.R.Body, cfg.MaxBody+1))
+		if err == ErrRequestEntityTooLarge || int64(len(body)) > cfg.MaxBody {
+			c.Abort().SendStatus(ErrRequestEntityTooLarge.Code, ErrRequestEntityTooLarge.Message)
+			return
+		}
+		if err != nil {
+			c.Abort().SendStatus(StatusBadRequest, StatusMessage(StatusBadRequest))
+			return
+		}
+		c.R.Body = io.NopCloser(bytes.NewReader(body))
+		want := hmacSignature(key, c.Method(), c.R.URL.RequestURI(), c.GetHeader(HeaderDate), body)
+		if !hmac.Equal(sig, want) || !seen.add(keyID+":"+string(sig), date.Add(cfg.Window)) {
+			unauthorized(c, challenge)
+			return
+		}
+		c.SetPrincipal(&Principal{ID: keyID, Scheme: "hmac"})
+	}
+}
+
+// SignRequest sign a request for HMACAuth, the Date is set when missing
+func SignRequest(r *http.Request, keyID string, secret []byte) error {
+	var body []byte
+	if r.Body != nil {
+		var err error
+		if body, err = io.ReadAll(r.Body); err != nil {
+			return err
+		}
+		r.Body.Close()
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	if r.Header.Get(HeaderDate) == "" {
+		r.Header.Set(HeaderDate, time.Now().UTC().Format(http.TimeFormat))
+	}
+	sig := hmacSignature(secret, r.Method, r.URL.RequestURI(), r.Header.Get(HeaderDate), body)
+	r.Header.Set(HeaderAuthorization, fmt.Sprintf(`%s keyId="%s", signature="%s"`,
+		HMACScheme, keyID, base64.StdEncoding.EncodeToString(sig)))
+	return nil
+}
+
+// hmacSignature sign the method, the request uri, the date and the hex
+// sha256 of the body, one per line
+func hmacSignature(secret []byte, method, uri, date string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(strings.ToUpper(method) + "\n" + uri + "\n" + date + "\n" + hex.EncodeToString(digest[:])))
+	return mac.Sum(nil)
+}
+
+func parseHMACAuthorization(h string) (keyID string, sig []byte, ok bool) {
+	scheme, params, _ := strings.Cut(h, " ")
+	if !strings.EqualFold(scheme, HMACScheme) {
+		return "", nil, false
+	}
+	for _, p := range strings.Split(params, ",") {
+		k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+		v = strings.Trim(v, `"`)
+		switch k {
+		case "keyId":
+			keyID = v
+		case "signature":
+			sig, _ = base64.StdEncoding.DecodeString(v)
+		}
+	}
+	return keyID, sig, keyID != "" && len(sig) > 0
+}
+
+// replayCache remember the signatures until the end of their window
+type replayCache struct {
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	swept time.Time
+}
+
+// add returns false when key was seen before
+func (r *replayCache) add(key string, expires time.Time) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	if now.Sub(r.swept) > time.Minute {
+		for k, exp := range r.seen {
+			if now.After(exp) {
+				delete(r.seen, k)
+			}
+		}
+		r.swept = now
+	}
+	if _, ok := r.seen[key]; ok {
+		return false
+	}
+	r.seen[key] = expires
+	return true
+}
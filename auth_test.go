@@ -0,0 +1,109 @@
+package core
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func authApp(mw HandlerFunc) *Core {
+	app := New()
+	app.Use(mw)
+	app.Post("/", func(c *Ctx) {
+		p := c.Principal()
+		c.SendString(p.Scheme + ":" + p.ID)
+	})
+	return app
+}
+
+func authServe(app *Core, req *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	return w
+}
+
+func TestBasicAuth(t *testing.T) {
+	app := authApp(BasicAuth(BasicAuthConfig{Users: Users{"ann": "s3cr3t"}}))
+	for user, pass := range map[string]string{"ann": "wrong", "bob": "", "": "s3cr3t"} {
+		req := httptest.NewRequest("POST", "/", nil)
+		req.SetBasicAuth(user, pass)
+		if w := authServe(app, req); w.Code != StatusUnauthorized || !strings.HasPrefix(w.Header().Get(HeaderWWWAuthenticate), "Basic realm=") {
+			t.Fatalf("%s:%s got %d", user, pass, w.Code)
+		}
+	}
+	req := httptest.NewRequest("POST", "/", nil)
+	req.SetBasicAuth("ann", "s3cr3t")
+	if body := authServe(app, req).Body.String(); body != "basic:ann" {
+		t.Fatalf("got %q", body)
+	}
+}
+
+func TestAPIKey(t *testing.T) {
+	app := authApp(APIKey(APIKeyConfig{Query: "api_key", Keys: map[string]string{"k1": "billing"}}))
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-API-Key", "k1")
+	if body := authServe(app, req).Body.String(); body != "api_key:billing" {
+		t.Fatalf("header got %q", body)
+	}
+	if body := authServe(app, httptest.NewRequest("POST", "/?api_key=k1", nil)).Body.String(); body != "api_key:billing" {
+		t.Fatalf("query got %q", body)
+	}
+	if w := authServe(app, httptest.NewRequest("POST", "/?api_key=k2", nil)); w.Code != StatusUnauthorized {
+		t.Fatalf("unknown key got %d", w.Code)
+	}
+
+	app = authApp(APIKey(APIKeyConfig{Validate: func(c *Ctx, key string) (*Principal, error) {
+		if key == "dyn" {
+			return &Principal{ID: "svc"}, nil
+		}
+		return nil, nil
+	}}))
+	req = httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-API-Key", "dyn")
+	if body := authServe(app, req).Body.String(); body != "api_key:svc" {
+		t.Fatalf("validator got %q", body)
+	}
+}
+
+func TestHMACAuth(t *testing.T) {
+	app := authApp(HMACAuth(HMACAuthConfig{Keys: map[string]string{"github": "secret"}, Window: time.Minute, MaxBody: 1 << 10}))
+	signed := func(body string, date time.Time, secret string) *http.Request {
+		req := httptest.NewRequest("POST", "/?event=push", strings.NewReader(body))
+		req.Header.Set(HeaderDate, date.UTC().Format(http.TimeFormat))
+		if err := SignRequest(req, "github", []byte(secret)); err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+
+	req := signed(`{"ref":"main"}`, time.Now(), "secret")
+	auth := req.Header.Get(HeaderAuthorization)
+	if body := authServe(app, req).Body.String(); body != "hmac:github" {
+		t.Fatalf("got %q", body)
+	}
+	// the same signature again
+	replay := httptest.NewRequest("POST", "/?event=push", strings.NewReader(`{"ref":"main"}`))
+	replay.Header = req.Header.Clone()
+	replay.Header.Set(HeaderAuthorization, auth)
+	if w := authServe(app, replay); w.Code != StatusUnauthorized {
+		t.Fatalf("replay got %d", w.Code)
+	}
+
+	tampered := signed(`{"ref":"main"}`, time.Now().Add(time.Second), "secret")
+	tampered.Body = io.NopCloser(strings.NewReader(`{"ref":"evil"}`))
+	if w := authServe(app, tampered); w.Code != StatusUnauthorized {
+		t.Fatalf("tampered body got %d", w.Code)
+	}
+	if w := authServe(app, signed("", time.Now().Add(-2*time.Minute), "secret")); w.Code != StatusUnauthorized {
+		t.Fatalf("stale date got %d", w.Code)
+	}
+	if w := authServe(app, signed("", time.Now(), "other")); w.Code != StatusUnauthorized {
+		t.Fatalf("wrong secret got %d", w.Code)
+	}
+	if w := authServe(app, signed(strings.Repeat("x", 2<<10), time.Now(), "secret")); w.Code != StatusRequestEntityTooLarge {
+		t.Fatalf("large body got %d", w.Code)
+	}
+}
@@ -0,0 +1,446 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const cacheTagsKey = "cache_tags"
+
+// CacheEntry a cached response
+type CacheEntry struct {
+	Status  int
+	Header  http.Header
+	Body    []byte
+	Path    string
+	Tags    []string
+	Vary    []string // set on the entry of a url whose responses vary, the variants have their own
+	Created time.Time
+	Expires time.Time // fresh until
+	Stale   time.Time // served while revalidating until
+}
+
+// CacheStore keep the cached responses, Get returns the entries until their
+// Stale time
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, e *CacheEntry)
+	Delete(key string)
+	PurgeTag(tag string) int       // the entries of a tag of CacheTags
+	PurgePrefix(prefix string) int // the entries whose path starts with prefix
+}
+
+// CacheConfig options of the Cache middleware
+//
+//	cache:
+//	  ttl: 1m                      # when the handler sets no max-age
+//	  stale_while_revalidate: 30s  # stale responses are served while refreshed
+//	  query: [page, sort]          # query params of the key, default all of them
+//	  max_entries: 10000
+//	  max_body_size: 1048576       # larger responses are not cached
+type CacheConfig struct {
+	TTL                  time.Duration
+	StaleWhileRevalidate time.Duration
+	Query                []string
+	MaxBodySize          int
+	Store                CacheStore // default the CacheStore of Core, max_entries is its size
+}
+
+// NewCacheConfig returns the CacheConfig of the cache section
+func NewCacheConfig(conf Options) CacheConfig {
+	return CacheConfig{
+		TTL:                  conf.GetDuration("ttl", time.Minute),
+		StaleWhileRevalidate: conf.GetDuration("stale_while_revalidate"),
+		Query:                conf.GetStrings("query"),
+		MaxBodySize:          conf.GetInt("max_body_size", 1<<20),
+	}
+}
+
+// CacheTags tag the response for CacheStore.PurgeTag
+//
+//	c.CacheTags("posts", "post:"+id)
+//	app.CacheStore.PurgeTag("post:" + id) // once it changes
+func (c *Ctx) CacheTags(tags ...string) {
+	old, _ := c.Get(cacheTagsKey)
+	prev, _ := old.([]string)
+	c.Set(cacheTagsKey, append(prev, tags...))
+}
+
+// cacheRevalidate marks the requests refreshing a stale entry
+type cacheRevalidate struct{}
+
+// Cache middleware cache the GET responses in the store and answer GET
+// requests from it. The key is the path, the query params and the
+// request headers of the Vary of the response. The Cache-Control of the
+// handler is honoured: no-store, no-cache and private are not cached,
+// max-age, s-maxage and stale-while-revalidate override the config.
+// Concurrent misses of a key wait for the first one, responses with cookies,
+// requests with Authorization and streamed bodies are not cached. Without
+// conf it is configured by the cache section.
+func Cache(conf ...CacheConfig) HandlerFunc {
+	var (
+		once    sync.Once
+		cfg     CacheConfig
+		flights = &cacheFlights{m: map[string]chan struct{}{}}
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewCacheConfig(c.Core().Conf.GetMap("cache"))
+			}
+			if cfg.TTL <= 0 {
+				cfg.TTL = time.Minute
+			}
+			if cfg.MaxBodySize <= 0 {
+				cfg.MaxBodySize = 1 << 20
+			}
+			if cfg.Store == nil {
+				cfg.Store = c.Core().CacheStore
+			}
+		})
+		if c.Method() != MethodGet || c.GetHeader(HeaderAuthorization) != "" {
+			return
+		}
+		key := cfg.key(c.R)
+		if c.R.Context().Value(cacheRevalidate{}) != nil {
+			cfg.fill(c, key)
+			return
+		}
+		if e, ok := cfg.lookup(key, c.R); ok {
+			if time.Now().Before(e.Expires) {
+				e.write(c, "HIT")
+				return
+			}
+			// stale, one request refreshes it in the background
+			if _, first := flights.join("r|" + key); first {
+				req := c.R.Clone(context.WithValue(context.Background(), cacheRevalidate{}, true))
+				go cfg.revalidate(c.Core(), req, flights, key)
+			}
+			e.write(c, "STALE")
+			return
+		}
+		if wait, first := flights.join(key); !first {
+			select {
+			case <-wait:
+			case <-c.Context.Done():
+				return
+			}
+			if e, ok := cfg.lookup(key, c.R); ok {
+				e.write(c, "HIT")
+				return
+			}
+			// not cacheable, each of the requests runs the handlers
+		} else {
+			defer flights.done(key)
+		}
+		cfg.fill(c, key)
+	}
+}
+
+// key returns the key of a request
+func (cfg *CacheConfig) key(r *http.Request) string {
+	q := r.URL.Query()
+	if len(cfg.Query) > 0 {
+		selected := url.Values{}
+		for _, k := range cfg.Query {
+			if v, ok := q[k]; ok {
+				selected[k] = v
+			}
+		}
+		q = selected
+	}
+	return MethodGet + " " + r.URL.Path + "?" + q.Encode() // Encode sorts by key
+}
+
+// varyKey returns the part of the key of the request headers of vary
+func varyKey(vary []string, r *http.Request) string {
+	var b strings.Builder
+	for _, h := range vary {
+		b.WriteString("|" + strings.ToLower(h) + "=" + strings.Join(r.Header.Values(h), ","))
+	}
+	return b.String()
+}
+
+// lookup returns the entry of the request, the one of its variant when the
+// responses vary
+func (cfg *CacheConfig) lookup(key string, r *http.Request) (*CacheEntry, bool) {
+	e, ok := cfg.Store.Get(key)
+	if ok && len(e.Vary) > 0 {
+		e, ok = cfg.Store.Get(key + varyKey(e.Vary, r))
+	}
+	return e, ok
+}
+
+// fill run the handlers capturing the response and cache it when allowed
+func (cfg *CacheConfig) fill(c *Ctx, key string) {
+	w := &captureWriter{ResponseWriter: c.W, max: cfg.MaxBodySize}
+	w.Header().Set("X-Cache", "MISS")
+	prev := c.W
+	c.W = w
+	c.Next()
+	c.W = prev
+	if w.skip {
+		return
+	}
+	e, ok := cfg.entry(c, w)
+	if !ok {
+		return
+	}
+	if len(e.Vary) > 0 {
+		// the entry of the url tells the headers its variants are keyed by
+		cfg.Store.Set(key, &CacheEntry{Path: e.Path, Vary: e.Vary, Created: e.Created, Expires: e.Expires, Stale: e.Stale})
+		key += varyKey(e.Vary, c.R)
+		e.Vary = nil
+	}
+	cfg.Store.Set(key, e)
+}
+
+// entry returns the entry of a captured response, false when it may not be
+// cached
+func (cfg *CacheConfig) entry(c *Ctx, w *captureWriter) (*CacheEntry, bool) {
+	switch w.Status() {
+	case StatusOK, StatusNonAuthoritativeInformation, StatusMultipleChoices, StatusMovedPermanently, StatusNotFound, StatusGone:
+	default:
+		return nil, false
+	}
+	h := w.Header()
+	if h.Get(HeaderSetCookie) != "" {
+		return nil, false
+	}
+	ttl, swr, shared := cfg.TTL, cfg.StaleWhileRevalidate, false
+	for _, d := range strings.Split(h.Get(HeaderCacheControl), ",") {
+		name, value, _ := strings.Cut(strings.TrimSpace(strings.ToLower(d)), "=")
+		secs, _ := strconv.Atoi(value)
+		switch name {
+		case "no-store", "no-cache", "private":
+			return nil, false
+		case "max-age":
+			if !shared {
+				ttl = time.Duration(secs) * time.Second
+			}
+		case "s-maxage": // for shared caches, wins over max-age
+			ttl, shared = time.Duration(secs)*time.Second, true
+		case "stale-while-revalidate":
+			swr = time.Duration(secs) * time.Second
+		}
+	}
+	if ttl <= 0 {
+		return nil, false
+	}
+	var vary []string
+	for _, v := range h.Values(HeaderVary) {
+		for _, f := range strings.Split(v, ",") {
+			if f = strings.TrimSpace(f); f == "*" {
+				return nil, false
+			} else if f != "" {
+				vary = append(vary, http.CanonicalHeaderKey(f))
+			}
+		}
+	}
+	sort.Strings(vary)
+	header := h.Clone()
+	for _, k := range []string{HeaderConnection, "Keep-Alive", HeaderTransferEncoding, HeaderDate, "Age", "X-Cache"} {
+		header.Del(k)
+	}
+	tags, _ := c.Get(cacheTagsKey)
+	now := time.Now()
+	e := &CacheEntry{
+		Status:  w.Status(),
+		Header:  header,
+		Body:    w.body,
+		Path:    c.R.URL.Path,
+		Vary:    vary,
+		Created: now,
+		Expires: now.Add(ttl),
+		Stale:   now.Add(ttl + swr),
+	}
+	e.Tags, _ = tags.([]string)
+	return e, true
+}
+
+// revalidate refresh a stale entry by the request in the background
+func (cfg *CacheConfig) revalidate(core *Core, req *http.Request, flights *cacheFlights, key string) {
+	defer flights.done("r|" + key)
+	defer func() {
+		// no Recovery runs around a background request
+		if err := recover(); err != nil {
+			Erro("cache: revalidate %s: %v\n%s", req.URL.Path, err, debug.Stack())
+		}
+	}()
+	core.ServeHTTP(&discardWriter{header: http.Header{}}, req)
+}
+
+// write answer the request by the entry and stop the handlers
+func (e *CacheEntry) write(c *Ctx, state string) {
+	h := c.W.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.Itoa(int(time.Since(e.Created)/time.Second)))
+	h.Set("X-Cache", state)
+	c.Abort()
+	c.W.WriteHeader(e.Status)
+	if len(e.Body) == 0 {
+		c.W.DoWriteHeader()
+		return
+	}
+	c.W.Write(e.Body)
+}
+
+// captureWriter a ResponseWriter keeping a copy of the body it writes, a
+// streamed or too large body is not kept
+type captureWriter struct {
+	ResponseWriter
+	body []byte
+	max  int
+	skip bool
+}
+
+func (w *captureWriter) Write(p []byte) (int, error) {
+	if !w.skip {
+		if len(w.body)+len(p) > w.max {
+			w.skip, w.body = true, nil
+		} else {
+			w.body = append(w.body, p...)
+		}
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *captureWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *captureWriter) Flush() {
+	w.skip, w.body = true, nil
+	w.ResponseWriter.Flush()
+}
+
+func (w *captureWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.skip, w.body = true, nil
+	return w.ResponseWriter.Hijack()
+}
+
+// discardWriter the http.ResponseWriter of background revalidations
+type discardWriter struct {
+	header http.Header
+}
+
+func (w *discardWriter) Header() http.Header         { return w.header }
+func (w *discardWriter) Write(p []byte) (int, error) { return len(p), nil }
+func (w *discardWriter) WriteHeader(int)             {}
+func (w *discardWriter) Flush()                      {}
+
+// cacheFlights the keys whose responses are being made
+type cacheFlights struct {
+	mu sync.Mutex
+	m  map[string]chan struct{}
+}
+
+// join returns the channel closed once the key is done, true for the first
+// caller who has to call done
+func (f *cacheFlights) join(key string) (chan struct{}, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if ch, ok := f.m[key]; ok {
+		return ch, false
+	}
+	ch := make(chan struct{})
+	f.m[key] = ch
+	return ch, true
+}
+
+func (f *cacheFlights) done(key string) {
+	f.mu.Lock()
+	close(f.m[key])
+	delete(f.m, key)
+	f.mu.Unlock()
+}
+
+// MemoryCacheStore a CacheStore of this process
+type MemoryCacheStore struct {
+	mu         sync.RWMutex
+	entries    map[string]*CacheEntry
+	MaxEntries int // 0 is unlimited
+}
+
+// NewMemoryCacheStore returns an empty MemoryCacheStore
+func NewMemoryCacheStore(maxEntries int) *MemoryCacheStore {
+	return &MemoryCacheStore{entries: map[string]*CacheEntry{}, MaxEntries: maxEntries}
+}
+
+func (s *MemoryCacheStore) Get(key string) (*CacheEntry, bool) {
+	s.mu.RLock()
+	e, ok := s.entries[key]
+	s.mu.RUnlock()
+	if ok && time.Now().After(e.Stale) {
+		s.Delete(key)
+		return nil, false
+	}
+	return e, ok
+}
+
+func (s *MemoryCacheStore) Set(key string, e *CacheEntry) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.entries[key]; !ok && s.MaxEntries > 0 && len(s.entries) >= s.MaxEntries {
+		now := time.Now()
+		for k, old := range s.entries {
+			if now.After(old.Stale) {
+				delete(s.entries, k)
+			}
+		}
+		for k := range s.entries { // still full, drop any
+			if len(s.entries) < s.MaxEntries {
+				break
+			}
+			delete(s.entries, k)
+		}
+	}
+	s.entries[key] = e
+}
+
+func (s *MemoryCacheStore) Delete(key string) {
+	s.mu.Lock()
+	delete(s.entries, key)
+	s.mu.Unlock()
+}
+
+func (s *MemoryCacheStore) PurgeTag(tag string) int {
+	return s.purge(func(e *CacheEntry) bool {
+		for _, t := range e.Tags {
+			if t == tag {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+func (s *MemoryCacheStore) PurgePrefix(prefix string) int {
+	return s.purge(func(e *CacheEntry) bool { return strings.HasPrefix(e.Path, prefix) })
+}
+
+func (s *MemoryCacheStore) purge(match func(e *CacheEntry) bool) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := 0
+	for k, e := range s.entries {
+		if match(e) {
+			delete(s.entries, k)
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	var calls int64
+	app := New()
+	app.Use(Cache(CacheConfig{TTL: time.Minute, Query: []string{"page"}}))
+	app.Get("/posts", func(c *Ctx) {
+		n := atomic.AddInt64(&calls, 1)
+		c.CacheTags("posts")
+		c.SendString("posts " + strconv.Itoa(int(n)))
+	})
+	app.Get("/hello", func(c *Ctx) {
+		atomic.AddInt64(&calls, 1)
+		c.SetHeader(HeaderVary, "Accept-Language")
+		c.SendString("hello " + c.GetHeader("Accept-Language"))
+	})
+	app.Get("/private", func(c *Ctx) {
+		atomic.AddInt64(&calls, 1)
+		c.SetHeader(HeaderCacheControl, "private, max-age=60")
+		c.SendString("mine")
+	})
+	app.Get("/stream", func(c *Ctx) {
+		atomic.AddInt64(&calls, 1)
+		c.Stream(func(w io.Writer) bool { io.WriteString(w, "tick"); return false })
+	})
+	get := func(path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+	expect := func(path, state, body string, wantCalls int64, headers ...string) {
+		t.Helper()
+		w := get(path, headers...)
+		if w.Header().Get("X-Cache") != state || w.Body.String() != body || atomic.LoadInt64(&calls) != wantCalls {
+			t.Fatalf("%s: %s %q after %d calls", path, w.Header().Get("X-Cache"), w.Body.String(), calls)
+		}
+	}
+
+	expect("/posts?page=1", "MISS", "posts 1", 1)
+	expect("/posts?page=1&utm=mail", "HIT", "posts 1", 1)
+	expect("/posts?page=2", "MISS", "posts 2", 2)
+	expect("/hello", "MISS", "hello en", 3, "Accept-Language", "en")
+	expect("/hello", "MISS", "hello fr", 4, "Accept-Language", "fr")
+	expect("/hello", "HIT", "hello en", 4, "Accept-Language", "en")
+	expect("/private", "MISS", "mine", 5)
+	expect("/private", "MISS", "mine", 6)
+	expect("/stream", "MISS", "tick", 7)
+	expect("/stream", "MISS", "tick", 8)
+
+	if n := app.CacheStore.PurgeTag("posts"); n != 2 {
+		t.Fatalf("purged %d by tag", n)
+	}
+	expect("/posts?page=1", "MISS", "posts 9", 9)
+	if n := app.CacheStore.PurgePrefix("/hel"); n != 3 {
+		t.Fatalf("purged %d by prefix", n)
+	}
+	expect("/hello", "MISS", "hello en", 10, "Accept-Language", "en")
+}
+
+func TestCacheCoalescing(t *testing.T) {
+	var calls int64
+	release := make(chan struct{})
+	app := New()
+	app.Use(Cache(CacheConfig{TTL: 50 * time.Millisecond, StaleWhileRevalidate: time.Minute}))
+	app.Get("/slow", func(c *Ctx) {
+		n := atomic.AddInt64(&calls, 1)
+		if n == 1 {
+			<-release
+		}
+		c.SendString("v" + strconv.Itoa(int(n)))
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/slow", nil))
+		return w
+	}
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 8)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = get().Body.String()
+		}(i)
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	for _, b := range bodies {
+		if b != "v1" {
+			t.Fatalf("bodies %v after %d calls", bodies, calls)
+		}
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if w := get(); w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "v1" {
+		t.Fatalf("expired entry got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+	// refreshed in the background
+	w := get()
+	for i := 0; i < 100 && w.Body.String() != "v2"; i++ {
+		time.Sleep(5 * time.Millisecond)
+		w = get()
+	}
+	if w.Header().Get("X-Cache") != "HIT" || w.Body.String() != "v2" || atomic.LoadInt64(&calls) != 2 {
+		t.Fatalf("revalidated entry got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+	}
+}
+
+func TestCacheRevalidatePanic(t *testing.T) {
+	var calls int64
+	app := New()
+	app.Use(Cache(CacheConfig{TTL: 10 * time.Millisecond, StaleWhileRevalidate: time.Minute}))
+	app.Get("/boom", func(c *Ctx) {
+		if atomic.AddInt64(&calls, 1) > 1 {
+			panic("boom")
+		}
+		c.SendString("v1")
+	})
+	get := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", "/boom", nil))
+		return w
+	}
+
+	get()
+	time.Sleep(20 * time.Millisecond)
+	// each stale hit starts a refresh once the failed one is done
+	for i := 0; i < 100 && atomic.LoadInt64(&calls) < 3; i++ {
+		if w := get(); w.Header().Get("X-Cache") != "STALE" || w.Body.String() != "v1" {
+			t.Fatalf("got %s %q", w.Header().Get("X-Cache"), w.Body.String())
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if n := atomic.LoadInt64(&calls); n < 3 {
+		t.Fatalf("revalidated %d times", n-1)
+	}
+}
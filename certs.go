@@ -0,0 +1,362 @@
+package core
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var ErrNoCertificate = errors.New("tls: no certificate available")
+
+// CertInfo describe a loaded certificate
+type CertInfo struct {
+	File     string
+	Names    []string
+	NotAfter time.Time
+}
+
+// CertManager serve certificates through tls.Config.GetCertificate, pick
+// them by SNI and reload them atomically when the files change on disk.
+//
+//	tls:
+//	  cert: ./certs/server.crt      # default certificate
+//	  key: ./certs/server.key
+//	  certificates:                 # more certificates
+//	    - cert: ./certs/admin.crt
+//	      key: ./certs/admin.key
+//	  cert_dir: ./certs/sni         # every name.crt|name.pem with a name.key
+//	  reload_interval: 1m           # poll interval of the files. default 1m
+//	  expiry_warning: 336h          # warn when a certificate expires sooner. default 14 days
+type CertManager struct {
+	mu            sync.Mutex
+	files         [][2]string
+	dir           string
+	interval      time.Duration
+	expiryWarning time.Duration
+	store         atomic.Value // *certStore
+	stamp         string
+	stop          context.CancelFunc
+}
+
+type certStore struct {
+	names map[string]*tls.Certificate
+	def   *tls.Certificate
+	infos []CertInfo
+}
+
+// NewCertManager create a manager from the tls config section and load
+// the certificates
+func NewCertManager(conf ...Options) (*CertManager, error) {
+	m := &CertManager{
+		interval:      time.Minute,
+		expiryWarning: 14 * 24 * time.Hour,
+	}
+	m.store.Store(&certStore{names: map[string]*tls.Certificate{}})
+	if len(conf) == 0 {
+		return m, nil
+	}
+	opt := conf[0]
+	if cert := opt.GetString("cert"); cert != "" {
+		m.files = append(m.files, [2]string{cert, opt.GetString("key")})
+	}
+	pairs, err := certPairs(opt["certificates"])
+	if err != nil {
+		return nil, err
+	}
+	m.files = append(m.files, pairs...)
+	m.dir = opt.GetString("cert_dir")
+	m.interval = opt.GetDuration("reload_interval", m.interval)
+	m.expiryWarning = opt.GetDuration("expiry_warning", m.expiryWarning)
+	return m, m.Reload()
+}
+
+// certPairs returns the key pairs of the certificates list, as decoded from
+// yaml or given as []Options
+func certPairs(list interface{}) ([][2]string, error) {
+	var items []interface{}
+	switch v := list.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		items = v
+	case []Options:
+		for _, o := range v {
+			items = append(items, o)
+		}
+	case []map[string]interface{}:
+		for _, o := range v {
+			items = append(items, o)
+		}
+	default:
+		return nil, fmt.Errorf("tls: certificates: unsupported %T", list)
+	}
+	pairs := make([][2]string, 0, len(items))
+	for _, item := range items {
+		var pair Options
+		switch v := item.(type) {
+		case Options:
+			pair = v
+		case map[string]interface{}:
+			pair = Options(v)
+		default:
+			return nil, fmt.Errorf("tls: certificates: unsupported item %T", item)
+		}
+		pairs = append(pairs, [2]string{pair.GetString("cert"), pair.GetString("key")})
+	}
+	return pairs, nil
+}
+
+// HasCertificates reports whether a certificate source is configured
+func (m *CertManager) HasCertificates() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.files) > 0 || m.dir != ""
+}
+
+// AddFile add a key pair and reload
+func (m *CertManager) AddFile(certFile, keyFile string) error {
+	m.mu.Lock()
+	m.files = append(m.files, [2]string{certFile, keyFile})
+	m.mu.Unlock()
+	if err := m.Reload(); err != nil {
+		m.mu.Lock()
+		m.files = m.files[:len(m.files)-1]
+		m.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// SetDir set the directory scanned for certificates and reload
+func (m *CertManager) SetDir(dir string) error {
+	m.mu.Lock()
+	m.dir = dir
+	m.mu.Unlock()
+	return m.Reload()
+}
+
+// Reload load all certificates and swap them in, on error the current
+// certificates are kept.
+func (m *CertManager) Reload() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files, err := m.pairs()
+	if err != nil {
+		return err
+	}
+	store := &certStore{names: make(map[string]*tls.Certificate)}
+	for _, pair := range files {
+		cert, err := loadCertificate(pair[0], pair[1])
+		if err != nil {
+			return err
+		}
+		if store.def == nil {
+			store.def = &cert
+		}
+		info := CertInfo{File: pair[0], Names: certNames(cert.Leaf), NotAfter: cert.Leaf.NotAfter}
+		for _, name := range info.Names {
+			if _, ok := store.names[name]; !ok {
+				store.names[name] = &cert
+			}
+		}
+		store.infos = append(store.infos, info)
+		if left := time.Until(info.NotAfter); left < m.expiryWarning {
+			Warn("tls: certificate %s %v expires in %s", info.File, info.Names, left.Round(time.Hour))
+		}
+	}
+	sort.Slice(store.infos, func(i, j int) bool {
+		return store.infos[i].NotAfter.Before(store.infos[j].NotAfter)
+	})
+	m.stamp = m.fingerprint(files)
+	m.store.Store(store)
+	return nil
+}
+
+// pairs returns configured files and the pairs found in dir
+func (m *CertManager) pairs() ([][2]string, error) {
+	files := append([][2]string{}, m.files...)
+	if m.dir == "" {
+		return files, nil
+	}
+	entries, err := os.ReadDir(m.dir)
+	if err != nil {
+		return nil, fmt.Errorf("tls: cert_dir: %w", err)
+	}
+	for _, e := range entries {
+		ext := filepath.Ext(e.Name())
+		if e.IsDir() || (ext != ".crt" && ext != ".pem") {
+			continue
+		}
+		key := filepath.Join(m.dir, strings.TrimSuffix(e.Name(), ext)+".key")
+		if Exists(key) {
+			files = append(files, [2]string{filepath.Join(m.dir, e.Name()), key})
+		}
+	}
+	return files, nil
+}
+
+func (m *CertManager) fingerprint(files [][2]string) string {
+	var b strings.Builder
+	for _, pair := range files {
+		for _, f := range pair {
+			if st, err := os.Stat(f); err == nil {
+				fmt.Fprintf(&b, "%s:%d:%d;", f, st.Size(), st.ModTime().UnixNano())
+			}
+		}
+	}
+	return b.String()
+}
+
+// changed reports whether a file was added, removed or modified since the
+// last reload
+func (m *CertManager) changed() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	files, err := m.pairs()
+	if err != nil {
+		return false
+	}
+	return m.fingerprint(files) != m.stamp
+}
+
+// GetCertificate select the certificate for the client hello by SNI,
+// wildcard certificates match one label, the first certificate is the default.
+func (m *CertManager) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	store := m.store.Load().(*certStore)
+	name := strings.ToLower(strings.TrimSuffix(hello.ServerName, "."))
+	if cert, ok := store.names[name]; ok {
+		return cert, nil
+	}
+	if i := strings.Index(name, "."); i > 0 {
+		if cert, ok := store.names["*"+name[i:]]; ok {
+			return cert, nil
+		}
+	}
+	if store.def == nil {
+		return nil, ErrNoCertificate
+	}
+	return store.def, nil
+}
+
+// Expiry returns the loaded certificates, soonest expiry first
+func (m *CertManager) Expiry() []CertInfo {
+	store := m.store.Load().(*certStore)
+	return append([]CertInfo{}, store.infos...)
+}
+
+// Watch poll the certificate files and reload them on change until ctx is done
+func (m *CertManager) Watch(ctx context.Context) error {
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if !m.changed() {
+				continue
+			}
+			if err := m.Reload(); err != nil {
+				Erro("tls: reload certificates: %s", err)
+				continue
+			}
+			Info("tls: certificates reloaded")
+		}
+	}
+}
+
+// Start watch in background, see Watch
+func (m *CertManager) Start() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		return
+	}
+	var ctx context.Context
+	ctx, m.stop = context.WithCancel(context.Background())
+	go m.Watch(ctx)
+}
+
+// Stop stop the background watcher
+func (m *CertManager) Stop() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stop != nil {
+		m.stop()
+		m.stop = nil
+	}
+}
+
+func certNames(leaf *x509.Certificate) []string {
+	names := make([]string, 0, len(leaf.DNSNames)+len(leaf.IPAddresses)+1)
+	for _, n := range leaf.DNSNames {
+		names = append(names, strings.ToLower(n))
+	}
+	for _, ip := range leaf.IPAddresses {
+		names = append(names, ip.String())
+	}
+	if len(names) == 0 && leaf.Subject.CommonName != "" {
+		names = append(names, strings.ToLower(leaf.Subject.CommonName))
+	}
+	return names
+}
+
+// GenerateSelfSigned create a self-signed ECDSA certificate for hosts, which
+// may be DNS names or IPs, returns PEM encoded certificate and key.
+func GenerateSelfSigned(validFor time.Duration, hosts ...string) (certPEM, keyPEM []byte, err error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, nil, err
+	}
+	tpl := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"core self-signed"}},
+		NotBefore:             time.Now().Add(-time.Minute),
+		NotAfter:              time.Now().Add(validFor),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	for _, h := range hosts {
+		if ip := net.ParseIP(h); ip != nil {
+			tpl.IPAddresses = append(tpl.IPAddresses, ip)
+		} else {
+			tpl.DNSNames = append(tpl.DNSNames, h)
+		}
+	}
+	if len(hosts) > 0 {
+		tpl.Subject.CommonName = hosts[0]
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tpl, tpl, &key.PublicKey, key)
+	if err != nil {
+		return nil, nil, err
+	}
+	keyDer, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	certPEM = pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM = pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDer})
+	return certPEM, keyPEM, nil
+}
@@ -0,0 +1,88 @@
+package core
+
+import (
+	"crypto/tls"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestCertManagerSNI(t *testing.T) {
+	dir := t.TempDir()
+	sni := t.TempDir()
+	cert, key := writeTestCert(t, dir, "default", "example.com")
+	writeTestCert(t, sni, "admin", "admin.example.com")
+	writeTestCert(t, sni, "wildcard", "*.apps.example.com")
+
+	m, err := NewCertManager(Options{"cert": cert, "key": key, "cert_dir": sni})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for host, want := range map[string]string{
+		"admin.example.com":     "admin.example.com",
+		"shop.apps.example.com": "*.apps.example.com",
+		"unknown.org":           "example.com",
+	} {
+		got, err := m.GetCertificate(&tls.ClientHelloInfo{ServerName: host})
+		if err != nil || got.Leaf.DNSNames[0] != want {
+			t.Fatalf("%s: expected %s, got %v err %v", host, want, got.Leaf.DNSNames, err)
+		}
+	}
+	if infos := m.Expiry(); len(infos) != 3 || infos[0].NotAfter.IsZero() {
+		t.Fatalf("unexpected expiry %+v", infos)
+	}
+}
+
+func TestCertManagerReload(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestCert(t, dir, "server", "old.example.com")
+	m, err := NewCertManager(Options{"cert": cert, "key": key})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m.changed() {
+		t.Fatalf("unexpected change right after load")
+	}
+
+	writeTestCert(t, dir, "server", "new.example.com")
+	later := time.Now().Add(time.Second)
+	os.Chtimes(cert, later, later)
+	if !m.changed() {
+		t.Fatalf("expected change to be detected")
+	}
+	if err = m.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := m.GetCertificate(&tls.ClientHelloInfo{ServerName: "new.example.com"})
+	if got.Leaf.DNSNames[0] != "new.example.com" {
+		t.Fatalf("certificate not reloaded: %v", got.Leaf.DNSNames)
+	}
+
+	// a broken file keeps the current certificate
+	os.WriteFile(cert, []byte("garbage"), 0600)
+	if err = m.Reload(); err == nil {
+		t.Fatalf("expected reload error")
+	}
+	if got, _ = m.GetCertificate(&tls.ClientHelloInfo{}); got.Leaf.DNSNames[0] != "new.example.com" {
+		t.Fatalf("current certificate replaced by a broken one")
+	}
+}
+
+func TestCertManagerList(t *testing.T) {
+	dir := t.TempDir()
+	cert, key := writeTestCert(t, dir, "api", "api.example.com")
+	for name, list := range map[string]interface{}{
+		"yaml":    []interface{}{map[string]interface{}{"cert": cert, "key": key}},
+		"options": []Options{{"cert": cert, "key": key}},
+		"maps":    []map[string]interface{}{{"cert": cert, "key": key}},
+		"mixed":   []interface{}{Options{"cert": cert, "key": key}},
+	} {
+		m, err := NewCertManager(Options{"certificates": list})
+		if err != nil || len(m.Expiry()) != 1 {
+			t.Fatalf("%s: err %v", name, err)
+		}
+	}
+	if _, err := NewCertManager(Options{"certificates": []string{cert}}); err == nil {
+		t.Fatal("unsupported list was accepted")
+	}
+}
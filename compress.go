@@ -0,0 +1,306 @@
+package core
+
+import (
+	"bufio"
+	"compress/gzip"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Content encodings of Compress
+const (
+	EncodingBrotli = "br"
+	EncodingZstd   = "zstd"
+	EncodingGzip   = "gzip"
+)
+
+// CompressConfig options of the Compress middleware
+//
+//	compress:
+//	  encodings: [br, zstd, gzip]  # preferred first, default this
+//	  min_size: 1024               # smaller bodies are sent as they are
+//	  types:                       # compressed content types, default text and the usual api ones
+//	    - text/*
+//	    - application/json
+//	    - application/*+json
+type CompressConfig struct {
+	Encodings []string
+	MinSize   int
+	Types     []string
+}
+
+var defaultCompressTypes = []string{
+	"text/*",
+	MIMEApplicationJSON,
+	"application/*+json",
+	MIMEApplicationXML,
+	"application/*+xml",
+	MIMEApplicationJavaScript,
+	"application/x-javascript",
+	"application/wasm",
+	"image/svg+xml",
+	"font/ttf",
+	"font/otf",
+}
+
+// NewCompressConfig returns the CompressConfig of the compress section
+func NewCompressConfig(conf Options) CompressConfig {
+	return CompressConfig{
+		Encodings: conf.GetStrings("encodings", []string{EncodingBrotli, EncodingZstd, EncodingGzip}),
+		MinSize:   conf.GetInt("min_size", 1024),
+		Types:     conf.GetStrings("types", defaultCompressTypes),
+	}
+}
+
+// Compress middleware compress the responses by the encoding negotiated
+// from Accept-Encoding. The body is held until MinSize bytes decide it, a
+// Flush, as of Stream, starts the compression at once. Responses of other
+// types, already encoded, partial or without a body are left alone. Without
+// conf it is configured by the compress section.
+func Compress(conf ...CompressConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  CompressConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewCompressConfig(c.Core().Conf.GetMap("compress"))
+			}
+			if len(cfg.Encodings) == 0 {
+				cfg.Encodings = []string{EncodingBrotli, EncodingZstd, EncodingGzip}
+			}
+			if len(cfg.Types) == 0 {
+				cfg.Types = defaultCompressTypes
+			}
+		})
+		if c.Method() == MethodHead {
+			return
+		}
+		w := &compressWriter{
+			ResponseWriter: c.W,
+			cfg:            &cfg,
+			encoding:       negotiateEncoding(c.GetHeader(HeaderAcceptEncoding), cfg.Encodings),
+		}
+		prev := c.W
+		c.W = w
+		defer func() {
+			w.close()
+			c.W = prev
+		}()
+		c.Next()
+	}
+}
+
+// negotiateEncoding returns the first of the offers the client accepts,
+// q=0 refuses one and * accepts the others
+func negotiateEncoding(accept string, offers []string) string {
+	if accept == "" {
+		return ""
+	}
+	q := map[string]float64{}
+	for _, part := range strings.Split(accept, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		weight := 1.0
+		if v, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				weight = f
+			}
+		}
+		q[strings.ToLower(strings.TrimSpace(name))] = weight
+	}
+	for _, offer := range offers {
+		weight, ok := q[offer]
+		if !ok {
+			weight, ok = q["*"]
+		}
+		if ok && weight > 0 {
+			return offer
+		}
+	}
+	return ""
+}
+
+// matchContentType match a media type against patterns as text/* or
+// application/*+json
+func matchContentType(ctype string, patterns []string) bool {
+	ctype, _, _ = strings.Cut(ctype, ";")
+	ctype = strings.ToLower(strings.TrimSpace(ctype))
+	for _, p := range patterns {
+		if prefix, suffix, ok := strings.Cut(p, "*"); ok {
+			if len(ctype) >= len(prefix)+len(suffix) && strings.HasPrefix(ctype, prefix) && strings.HasSuffix(ctype, suffix) {
+				return true
+			}
+		} else if ctype == p {
+			return true
+		}
+	}
+	return false
+}
+
+// encoder a pooled compressor
+type encoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var encoderPools = map[string]*sync.Pool{
+	EncodingGzip: {New: func() interface{} {
+		return gzip.NewWriter(nil)
+	}},
+	EncodingBrotli: {New: func() interface{} {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	EncodingZstd: {New: func() interface{} {
+		enc, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1), zstd.WithLowerEncoderMem(true))
+		return enc
+	}},
+}
+
+// compressWriter a ResponseWriter compressing what the handlers write into
+// the one it wraps, Size is the uncompressed size and Status the one of the
+// wrapped writer
+type compressWriter struct {
+	ResponseWriter
+	cfg      *CompressConfig
+	encoding string // negotiated, empty when the client accepts none
+	enc      encoder
+	buf      []byte
+	size     int
+	decided  bool
+}
+
+// decide whether the response is compressed, flush tells the body is
+// streamed so its size does not matter
+func (w *compressWriter) decide(flush bool) {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	h := w.Header()
+	status := w.Status()
+	if h.Get(HeaderContentEncoding) != "" || h.Get(HeaderContentRange) != "" ||
+		status < StatusOK || status == StatusNoContent || status == StatusNotModified || status == StatusPartialContent {
+		return
+	}
+	ctype := h.Get(HeaderContentType)
+	if ctype == "" && len(w.buf) > 0 {
+		// sniff the plain body, after this the one sent is encoded
+		ctype = http.DetectContentType(w.buf)
+		h.Set(HeaderContentType, ctype)
+	}
+	if !matchContentType(ctype, w.cfg.Types) {
+		return
+	}
+	// the body of this url depends on the encodings the client accepts
+	addVary(h, HeaderAcceptEncoding)
+	if w.encoding == "" || (!flush && len(w.buf) < w.cfg.MinSize) {
+		return
+	}
+	pool := encoderPools[w.encoding]
+	if pool == nil {
+		return
+	}
+	h.Set(HeaderContentEncoding, w.encoding)
+	h.Del(HeaderContentLength)
+	if etag := h.Get(HeaderETag); etag != "" && !strings.HasPrefix(etag, "W/") {
+		h.Set(HeaderETag, "W/"+etag) // other bytes than the ones of the strong tag
+	}
+	w.enc = pool.Get().(encoder)
+	w.enc.Reset(w.ResponseWriter)
+}
+
+// flushBuf write the held body out, through the encoder when compressing
+func (w *compressWriter) flushBuf() error {
+	if len(w.buf) == 0 {
+		return nil
+	}
+	buf := w.buf
+	w.buf = nil
+	if w.enc != nil {
+		_, err := w.enc.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	w.size += len(p)
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.cfg.MinSize {
+			return len(p), nil
+		}
+		w.decide(false)
+		return len(p), w.flushBuf()
+	}
+	if w.enc != nil {
+		return w.enc.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *compressWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Flush send what was written so far, compressed when the type allows it
+func (w *compressWriter) Flush() {
+	w.decide(true)
+	if err := w.flushBuf(); err == nil && w.enc != nil {
+		w.enc.Flush()
+	}
+	w.ResponseWriter.Flush()
+}
+
+func (w *compressWriter) DoWriteHeader() {
+	w.decide(false)
+	w.flushBuf()
+	w.ResponseWriter.DoWriteHeader()
+}
+
+// Size the uncompressed bytes the handlers wrote, the Logger reports them
+// and not the bytes sent
+func (w *compressWriter) Size() int {
+	if w.size == 0 && !w.decided {
+		return w.ResponseWriter.Size()
+	}
+	return w.size
+}
+
+func (w *compressWriter) Written() bool {
+	return w.size > 0 || w.ResponseWriter.Written()
+}
+
+// Hijack hand over the connection, nothing is compressed after
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.decided = true
+	return w.ResponseWriter.Hijack()
+}
+
+// close write the rest of the body and return the encoder to its pool
+func (w *compressWriter) close() {
+	if w.size > 0 {
+		w.decide(false)
+		w.flushBuf()
+	}
+	if w.enc != nil {
+		if err := w.enc.Close(); err != nil {
+			Warn("compress: %s", err)
+		}
+		w.enc.Reset(nil)
+		encoderPools[w.encoding].Put(w.enc)
+		w.enc = nil
+	}
+}
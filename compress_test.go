@@ -0,0 +1,133 @@
+package core
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+func TestCompress(t *testing.T) {
+	big := strings.Repeat(`{"name":"compressible"}`, 200)
+	var outerSize, innerSize, status int
+	app := New()
+	app.Use(func(c *Ctx) {
+		c.Next()
+		outerSize, status = c.W.Size(), c.W.Status()
+	})
+	app.Use(Compress(CompressConfig{MinSize: 512}))
+	app.Use(func(c *Ctx) {
+		c.Next()
+		innerSize = c.W.Size()
+	})
+	app.Get("/json", func(c *Ctx) {
+		c.SetHeader(HeaderContentLength, "4600")
+		c.SetHeader(HeaderETag, `"v1"`)
+		c.Status(StatusCreated)
+		c.SetHeader(HeaderContentType, MIMEApplicationJSON)
+		c.SendString(big)
+	})
+	app.Get("/small", func(c *Ctx) { c.SendString("tiny") })
+	app.Get("/png", func(c *Ctx) {
+		c.SetHeader(HeaderContentType, "image/png")
+		c.SendString(big)
+	})
+	app.Get("/encoded", func(c *Ctx) {
+		c.SetHeader(HeaderContentType, MIMETextPlain)
+		c.SetHeader(HeaderContentEncoding, "gzip")
+		c.SendString(big)
+	})
+	app.Get("/empty", func(c *Ctx) { c.SendStatus(StatusNoContent, "") })
+	app.Get("/stream", func(c *Ctx) {
+		c.SetHeader(HeaderContentType, "text/event-stream")
+		n := 0
+		c.Stream(func(w io.Writer) bool {
+			n++
+			io.WriteString(w, "data: tick\n\n")
+			return n < 3
+		})
+	})
+	get := func(path, accept string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		if accept != "" {
+			req.Header.Set(HeaderAcceptEncoding, accept)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+	decode := func(enc string, r io.Reader) string {
+		var dr io.Reader
+		switch enc {
+		case EncodingGzip:
+			gr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			dr = gr
+		case EncodingBrotli:
+			dr = brotli.NewReader(r)
+		case EncodingZstd:
+			zr, err := zstd.NewReader(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer zr.Close()
+			dr = zr
+		default:
+			dr = r
+		}
+		b, err := io.ReadAll(dr)
+		if err != nil {
+			t.Fatalf("%s: %s", enc, err)
+		}
+		return string(b)
+	}
+
+	for accept, want := range map[string]string{
+		"gzip, deflate":         EncodingGzip,
+		"gzip;q=0.5, br":        EncodingBrotli,
+		"zstd, gzip":            EncodingZstd,
+		"br;q=0, *":             EncodingZstd,
+		"identity":              "",
+		"gzip;q=0, br;q=0, x-y": "",
+	} {
+		w := get("/json", accept)
+		if got := w.Header().Get(HeaderContentEncoding); got != want {
+			t.Fatalf("%q: encoding %q want %q", accept, got, want)
+		}
+		if w.Header().Get(HeaderVary) != HeaderAcceptEncoding || w.Code != StatusCreated {
+			t.Fatalf("%q: vary %q status %d", accept, w.Header().Get(HeaderVary), w.Code)
+		}
+		if want != "" && (w.Header().Get(HeaderContentLength) != "" || w.Header().Get(HeaderETag) != `W/"v1"`) {
+			t.Fatalf("%q: length %q etag %q", accept, w.Header().Get(HeaderContentLength), w.Header().Get(HeaderETag))
+		}
+		if innerSize != len(big) || status != StatusCreated || (want != "" && outerSize != w.Body.Len()) {
+			t.Fatalf("%q: sizes %d %d status %d", accept, innerSize, outerSize, status)
+		}
+		if body := decode(want, w.Body); body != big {
+			t.Fatalf("%q: body of %d bytes", accept, len(body))
+		}
+	}
+
+	for _, path := range []string{"/small", "/png", "/empty"} {
+		if w := get(path, "gzip"); w.Header().Get(HeaderContentEncoding) != "" {
+			t.Fatalf("%s compressed", path)
+		}
+	}
+	if w := get("/encoded", "br"); w.Header().Get(HeaderContentEncoding) != "gzip" || w.Body.String() != big {
+		t.Fatalf("encoded body changed")
+	}
+
+	w := get("/stream", "gzip")
+	if w.Header().Get(HeaderContentEncoding) != EncodingGzip || !w.Flushed {
+		t.Fatalf("stream not compressed %v", w.Header())
+	}
+	if body := decode(EncodingGzip, w.Body); body != strings.Repeat("data: tick\n\n", 3) {
+		t.Fatalf("stream got %q", body)
+	}
+}
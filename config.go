@@ -1,10 +1,12 @@
 package core
 
 import (
+	"fmt"
 	"log"
 	"os"
 	"reflect"
 	"strconv"
+	"time"
 
 	"gopkg.in/yaml.v3"
 )
@@ -30,8 +32,11 @@ func (c *Options) GetString(k string, def ...string) string {
 
 func (c *Options) GetMap(k string, def ...Options) Options {
 	if val, ok := (*c)[k]; ok && val != nil {
-		if v, ok := val.(Options); ok {
+		switch v := val.(type) {
+		case Options:
 			return v
+		case map[string]interface{}: // nested sections decoded from yaml
+			return Options(v)
 		}
 	}
 	if len(def) > 0 {
@@ -55,8 +60,17 @@ func (c *Options) GetAs(k string, v interface{}) error {
 
 func (c *Options) GetStrings(k string, def ...[]string) []string {
 	if val, ok := (*c)[k]; ok && val != nil {
-		if v, ok := val.([]string); ok {
+		switch v := val.(type) {
+		case []string:
 			return v
+		case []interface{}: // lists decoded from yaml
+			strs := make([]string, 0, len(v))
+			for _, s := range v {
+				strs = append(strs, fmt.Sprint(s))
+			}
+			return strs
+		case string:
+			return []string{v}
 		}
 	}
 	if len(def) > 0 {
@@ -89,6 +103,31 @@ func (c *Options) GetInt64(k string, def ...int64) int64 {
 	return 0
 }
 
+// GetDuration returns the value as a duration, strings are parsed by
+// time.ParseDuration e.g "1m30s" and numbers are seconds
+func (c *Options) GetDuration(k string, def ...time.Duration) time.Duration {
+	if val, ok := (*c)[k]; ok && val != nil {
+		switch v := val.(type) {
+		case time.Duration:
+			return v
+		case string:
+			if d, err := time.ParseDuration(v); err == nil {
+				return d
+			}
+		case int:
+			return time.Duration(v) * time.Second
+		case int64:
+			return time.Duration(v) * time.Second
+		case float64:
+			return time.Duration(v * float64(time.Second))
+		}
+	}
+	if len(def) > 0 {
+		return def[0]
+	}
+	return 0
+}
+
 func (c *Options) GetBool(k string, def ...bool) bool {
 	val, ok := (*c)[k]
 	if !ok {
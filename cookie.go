@@ -0,0 +1,143 @@
+package core
+
+import (
+	"net/http"
+	"time"
+)
+
+// CookieOptions attributes of the cookies set by SetCookie and the signed
+// and encrypted variants
+type CookieOptions struct {
+	Path        string // default /
+	Domain      string // default the domain key
+	Expires     time.Time
+	MaxAge      int // seconds, negative removes the cookie
+	Secure      bool
+	HttpOnly    bool
+	SameSite    http.SameSite // default the one of SetSameSite, else lax
+	Partitioned bool          // CHIPS partitioned storage, needs Secure
+}
+
+// merge set the fields of o which are set
+func (opts *CookieOptions) merge(o CookieOptions) {
+	if o.Path != "" {
+		opts.Path = o.Path
+	}
+	if o.Domain != "" {
+		opts.Domain = o.Domain
+	}
+	if !o.Expires.IsZero() {
+		opts.Expires = o.Expires
+	}
+	if o.MaxAge != 0 {
+		opts.MaxAge = o.MaxAge
+	}
+	if o.SameSite != 0 {
+		opts.SameSite = o.SameSite
+	}
+	opts.Secure = opts.Secure || o.Secure
+	opts.HttpOnly = opts.HttpOnly || o.HttpOnly
+	opts.Partitioned = opts.Partitioned || o.Partitioned
+}
+
+// SetSameSite set the SameSite mode of the cookies of this request
+func (c *Ctx) SetSameSite(mode http.SameSite) {
+	c.sameSite = mode
+}
+
+// setCookie add the Set-Cookie header of an encoded value
+func (c *Ctx) setCookie(name, value string, opts CookieOptions) {
+	cookie := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     opts.Path,
+		Domain:   opts.Domain,
+		Expires:  opts.Expires,
+		MaxAge:   opts.MaxAge,
+		Secure:   opts.Secure,
+		HttpOnly: opts.HttpOnly,
+		SameSite: opts.SameSite,
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	if cookie.Domain == "" { // read config domain
+		cookie.Domain = c.Core().Conf.GetString("domain")
+	}
+	if cookie.SameSite == 0 {
+		cookie.SameSite = c.sameSite
+		if cookie.SameSite == http.SameSiteDefaultMode {
+			cookie.SameSite = http.SameSiteLaxMode
+		}
+	}
+	v := cookie.String()
+	if v == "" {
+		return
+	}
+	if opts.Partitioned {
+		v += "; Partitioned"
+	}
+	c.W.Header().Add(HeaderSetCookie, v)
+}
+
+func cookieOptions(opts []CookieOptions) CookieOptions {
+	var o CookieOptions
+	for _, opt := range opts {
+		o.merge(opt)
+	}
+	return o
+}
+
+// SetSignedCookie set a cookie whose value is signed by the first of the
+// keys, it is readable by the client but can not be changed.
+//
+//	keys:
+//	  - "new secret"   # signs
+//	  - "old secret"   # still verifies
+func (c *Ctx) SetSignedCookie(name, value string, opts ...CookieOptions) error {
+	if c.core.Keys == nil {
+		return ErrNoKeys
+	}
+	c.setCookie(name, c.core.Keys.Sign(name, value), cookieOptions(opts))
+	return nil
+}
+
+// SignedCookie returns the value of a cookie of SetSignedCookie,
+// ErrInvalidValue when it was changed or signed by an unknown key
+func (c *Ctx) SignedCookie(name string) (string, error) {
+	if c.core.Keys == nil {
+		return "", ErrNoKeys
+	}
+	ck, err := c.R.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return c.core.Keys.Verify(name, ck.Value)
+}
+
+// SetEncryptedCookie set a cookie whose value is encrypted with AES-GCM by
+// the first of the keys, the client can neither read nor change it.
+func (c *Ctx) SetEncryptedCookie(name, value string, opts ...CookieOptions) error {
+	if c.core.Keys == nil {
+		return ErrNoKeys
+	}
+	sealed, err := c.core.Keys.Encrypt(name, []byte(value))
+	if err != nil {
+		return err
+	}
+	c.setCookie(name, sealed, cookieOptions(opts))
+	return nil
+}
+
+// EncryptedCookie returns the value of a cookie of SetEncryptedCookie
+func (c *Ctx) EncryptedCookie(name string) (string, error) {
+	if c.core.Keys == nil {
+		return "", ErrNoKeys
+	}
+	ck, err := c.R.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	plain, err := c.core.Keys.Decrypt(name, ck.Value)
+	return string(plain), err
+}
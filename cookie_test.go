@@ -0,0 +1,61 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSignedEncryptedCookies(t *testing.T) {
+	app := New(Options{"keys": []interface{}{"secret"}})
+	app.Get("/set", func(c *Ctx) {
+		c.SetSignedCookie("user", "ann", CookieOptions{HttpOnly: true})
+		c.SetEncryptedCookie("token", "s3cr3t", CookieOptions{Secure: true, Partitioned: true, MaxAge: 60})
+	})
+	get := func(c *Ctx) {
+		user, err1 := c.SignedCookie("user")
+		token, err2 := c.EncryptedCookie("token")
+		if err1 != nil || err2 != nil {
+			c.SendString("invalid")
+			return
+		}
+		c.SendString(user + "|" + token)
+	}
+	app.Get("/get", get)
+	serve := func(app *Core, path string, cookies ...*http.Cookie) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		for _, ck := range cookies {
+			req.AddCookie(ck)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	w := serve(app, "/set")
+	cookies := w.Result().Cookies()
+	if len(cookies) != 2 || strings.Contains(cookies[1].Value, "s3cr3t") {
+		t.Fatalf("unexpected cookies %v", cookies)
+	}
+	if h := w.Header().Values(HeaderSetCookie)[1]; !strings.Contains(h, "Partitioned") || !strings.Contains(h, "Max-Age=60") ||
+		!strings.Contains(h, "SameSite=Lax") {
+		t.Fatalf("unexpected attributes %q", h)
+	}
+	if body := serve(app, "/get", cookies...).Body.String(); body != "ann|s3cr3t" {
+		t.Fatalf("got %q", body)
+	}
+
+	// the old key still verifies after a rotation
+	rotated := New(Options{"keys": []interface{}{"newer", "secret"}})
+	rotated.Get("/get", get)
+	if body := serve(rotated, "/get", cookies...).Body.String(); body != "ann|s3cr3t" {
+		t.Fatalf("rotated got %q", body)
+	}
+
+	tampered := *cookies[0]
+	tampered.Value = strings.Replace(tampered.Value, tampered.Value[:3], "Ym9i", 1)
+	if body := serve(app, "/get", &tampered, cookies[1]).Body.String(); body != "invalid" {
+		t.Fatalf("tampered cookie accepted %q", body)
+	}
+}
@@ -11,16 +11,13 @@ import (
 	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path"
 	"reflect"
-	"runtime"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/xs23933/core/reuseport"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -41,8 +38,25 @@ type Core struct {
 	MaxMultipartMemory int64
 	ViewFuncMap        template.FuncMap
 	RemoteIPHeaders    []string
+	ListenConfig       ListenConfig
+	Limits             Limits
 	Ln                 net.Listener
 	NotFoundFunc       NotFoundFunc
+	Certs              *CertManager
+	Keys               *Keyring
+	Authorizer         Authorizer
+	CacheStore         CacheStore
+	life               lifecycle
+	addrs              []string
+	listeners          []inheritedListener
+	supervisor         *supervisor
+	ipc                *IPC
+	conns              *connLimiter
+	trustedCIDRs       []*net.IPNet
+	trustUnix          bool
+	requests           counter
+	permMu             sync.Mutex
+	permissions        []RoutePermission
 	enablePrefork      bool
 	networkProto       string
 }
@@ -65,7 +79,7 @@ func (c *Core) Use(args ...interface{}) *Core {
 		switch a := arg.(type) {
 		case string:
 			path = a
-		case func(*Ctx), HandlerFunc, func(http.ResponseWriter, *http.Request), http.Handler:
+		case func(*Ctx), HandlerFunc, func(http.ResponseWriter, *http.Request), http.Handler, Requirement, CORSHandler:
 			handlers = append(handlers, a)
 		case Views:
 			c.Views = a
@@ -94,6 +108,9 @@ func (c *Core) buildHanders(h handler) {
 	if prefix == "" {
 		prefix = "/"
 	}
+	if ch, ok := h.(corsHandler); ok {
+		c.AddHandle(MethodUse, prefix, CORS(ch.CORSPolicy()))
+	}
 	c.AddHandle(MethodUse, prefix, h.Preload) // Register global preload
 	for i := 0; i < methodCount; i++ {
 		m := refCtl.Method(i)
@@ -103,7 +120,12 @@ func (c *Core) buildHanders(h handler) {
 			for _, method := range Methods {
 				if strings.HasPrefix(name, strings.ToLower(method)) {
 					name = fixURI(prefix, name, method)
-					c.AddHandle(method, name, fn)
+					if req := handlerRequirement(h, m.Name); len(req) > 0 {
+						c.addPermission([]string{method}, name, h.HandName()+"."+m.Name, req)
+						c.AddHandle(method, name, []interface{}{HandlerFunc(req.check), fn})
+					} else {
+						c.AddHandle(method, name, fn)
+					}
 					h.PushHandler(method, name)
 				}
 			}
@@ -251,13 +273,16 @@ func (c *Core) AddHandle(methods interface{}, path string, handler interface{},
 		path = "/"
 	}
 	D("%v: %s", methods, path)
+	var list []string
 	switch v := methods.(type) { // check method is string or []string
 	case string:
-		return c.tree.Insert([]string{v}, path, handler, static...)
+		list = []string{v}
 	case []string:
-		return c.tree.Insert(v, path, handler, static...)
+		list = v
+	default:
+		return ErrMethodNotAllowed
 	}
-	return ErrMethodNotAllowed
+	return c.tree.Insert(list, path, c.corsPolicies(list, path, c.requirements(list, path, handler)), static...)
 }
 
 func (c *Core) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -266,17 +291,35 @@ func (c *Core) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	atomic.AddInt64(&c.life.inflight, 1)
+	defer atomic.AddInt64(&c.life.inflight, -1)
 	ctx := c.assignCtx(w, r)
 	defer c.releaseCtx(ctx)
+	if max := c.Limits.MaxRequestsPerIP; max > 0 {
+		ip := ctx.RemoteIP().String()
+		if !c.requests.acquire(ip, max) {
+			ctx.SendStatus(StatusTooManyRequests, StatusMessage(StatusTooManyRequests))
+			return
+		}
+		defer c.requests.release(ip)
+	}
+	c.limitBody(ctx)
+	if isPreflight(r) && c.preflight(ctx) {
+		return
+	}
 	st := time.Now()
 	result, err := c.tree.Find(r.Method, r.URL.Path)
 	if err == nil {
 		ctx.params = result.params
+		ctx.route = result.route
 		ctx.handlers = append(result.preloads, result.handler...)
 		if result.static {
 			ctx.handlers = HandlerFuncs{result.handler[len(result.handler)-1]}
 		}
 		ctx.Next()
+		if bodyTooLarge(ctx) && !ctx.W.Written() {
+			ctx.SendStatus(StatusRequestEntityTooLarge, StatusMessage(StatusRequestEntityTooLarge))
+		}
 		// ctx.wm.DoWriteHeader()
 		return
 	}
@@ -309,7 +352,8 @@ func New(conf ...Options) *Core {
 		ViewFuncMap:     template.FuncMap{},
 		enablePrefork:   false,
 		networkProto:    "tcp4",
-		RemoteIPHeaders: []string{"X-Forwarded-For", "X-Real-IP"},
+		ListenConfig:    ListenConfig{Type: ListenerNet},
+		RemoteIPHeaders: []string{HeaderForwarded, HeaderXForwardedFor, "X-Real-IP"},
 		pool: sync.Pool{
 			New: func() interface{} {
 				return &Ctx{
@@ -317,19 +361,24 @@ func New(conf ...Options) *Core {
 				}
 			},
 		},
-		Server: &http.Server{},
+		Limits: Limits{MaxHeaderBytes: http.DefaultMaxHeaderBytes},
+		Server: &http.Server{ConnContext: connContext},
+		ipc:    newIPC(),
 	}
+	c.life.done = make(chan struct{})
 	c.Handler = c
 	c.NotFoundFunc = c.NotFound
+	c.Authorizer = NewRBAC(nil)
+	c.CacheStore = NewMemoryCacheStore(10000)
 	if len(conf) > 0 {
 		c.Conf = conf[0]
 		Conf = c.Conf
 		c.Debug = c.Conf.GetBool("debug")
-		c.addr = c.Conf.ToString("listen", ":http")
-		if !strings.Contains(c.addr, ":") {
-			c.addr = fmt.Sprintf(":%s", c.addr)
+		listen := c.Conf.GetStrings("listen")
+		if len(listen) == 0 {
+			listen = []string{c.Conf.ToString("listen", ":http")}
 		}
-		c.Server.Addr = c.addr
+		c.setAddrs(listen...)
 		// 配置信息
 		servConf := make(Options)
 		Conf.GetAs("server", &servConf)
@@ -339,11 +388,51 @@ func New(conf ...Options) *Core {
 			c.Server.WriteTimeout = time.Second * time.Duration(Conf.GetInt("write_timeout", 10))
 			c.Server.IdleTimeout = time.Second * time.Duration(Conf.GetInt("idle_timeout", 30))
 		}
-		c.enablePrefork = c.Conf.GetBool("prefork", false)
+		c.Limits = NewLimits(c.Conf.GetMap("server"))
+		c.Authorizer = NewRBAC(c.Conf.GetMap("rbac"))
+		cacheConf := c.Conf.GetMap("cache")
+		c.CacheStore = NewMemoryCacheStore(cacheConf.GetInt("max_entries", 10000))
+		c.Server.MaxHeaderBytes = c.Limits.MaxHeaderBytes
+		if err := c.SetTrustedProxies(c.Conf.GetStrings("trusted_proxies")...); err != nil {
+			panic(err)
+		}
+		if headers := c.Conf.GetStrings("remote_ip_headers"); len(headers) > 0 {
+			c.RemoteIPHeaders = headers
+		}
+		if secrets := c.Conf.GetStrings("keys"); len(secrets) > 0 {
+			keys, err := NewKeyring(secrets...)
+			if err != nil {
+				panic(err)
+			}
+			c.Keys = keys
+		}
+		if preforkConf := c.Conf.GetMap("prefork"); len(preforkConf) > 0 {
+			c.enablePrefork = preforkConf.GetBool("enable", true)
+		} else {
+			c.enablePrefork = c.Conf.GetBool("prefork", false)
+		}
+		c.ListenConfig = NewListenConfig(c.Conf.GetMap("listener"), c.enablePrefork)
 		c.assets = c.Conf.GetMap("static")
 		c.networkProto = c.Conf.GetString("network", "tcp4")
 
 		c.MaxMultipartMemory = c.Conf.GetInt64("maxMultipartMemory", defaultMultipartMemory)
+
+		if tlsConf := c.Conf.GetMap("tls"); len(tlsConf) > 0 {
+			tlsConfig, err := NewTLSConfig(tlsConf)
+			if err != nil {
+				panic(err)
+			}
+			if c.Certs, err = NewCertManager(tlsConf); err != nil {
+				panic(err)
+			}
+			if c.Certs.HasCertificates() {
+				tlsConfig.GetCertificate = c.Certs.GetCertificate
+			}
+			c.Server.TLSConfig = tlsConfig
+		}
+		if c.Conf.GetBool("h2c") {
+			c.EnableH2C()
+		}
 	}
 	return c
 }
@@ -383,22 +472,16 @@ func (c *Core) GoListenAndServeContext(ctx context.Context, addr ...string) erro
 		return ErrContextMustBeSet
 	}
 	if len(addr) > 0 {
-		c.addr = addr[0]
-	}
-	if c.addr == "" {
-		c.addr = ":8080"
+		c.setAddrs(addr...)
 	}
-	c.addr = FixedPort(c.addr)
-	ln, err := net.Listen("tcp", c.addr)
+	lns, err := c.listenAll("tcp", ":8080", c.ListenConfig.listen)
 	if err != nil {
 		return err
 	}
-
-	if tcpLn, ok := ln.(*net.TCPListener); ok {
-		ln = tcpKeepAliveListener{TCPListener: tcpLn}
+	for i, ln := range lns {
+		lns[i] = c.wrapListener(ln)
 	}
-
-	return c.GoServe(ctx, ln)
+	return c.GoServe(ctx, lns...)
 }
 
 func FixedPort(port string) string {
@@ -408,17 +491,20 @@ func FixedPort(port string) string {
 	return port
 }
 
-func (c *Core) GoServe(ctx context.Context, ln net.Listener) error {
+func (c *Core) GoServe(ctx context.Context, lns ...net.Listener) error {
 	c.waiterMux.Lock()
 	defer c.waiterMux.Unlock()
 	c.waiter, ctx = errgroup.WithContext(ctx)
+	for _, ln := range lns {
+		ln := ln
+		c.waiter.Go(func() error {
+			return c.Serve(ln)
+		})
+	}
 	c.waiter.Go(func() error {
-		return c.Serve(ln)
-	})
-	go func(ctx context.Context) {
 		<-ctx.Done()
-		c.Close()
-	}(ctx)
+		return c.Shutdown()
+	})
 	return nil
 }
 
@@ -441,115 +527,63 @@ func (c *Core) Wait() error {
 
 func (c *Core) ListenAndServe(addr ...string) error {
 	if len(addr) > 0 {
-		c.addr = addr[0]
-
-		if c.addr == "" {
-			c.addr = ":8080"
-		}
-		if !strings.Contains(c.addr, ":") {
-			c.addr = fmt.Sprintf(":%s", c.addr)
-		}
-		c.Server.Addr = c.addr
+		c.setAddrs(addr...)
 	}
 
 	if c.enablePrefork {
 		return c.prefork()
 	}
 
-	ln, err := net.Listen("tcp", c.Server.Addr)
+	lns, err := c.listenAll("tcp", c.Server.Addr, c.ListenConfig.listen)
 	if err != nil {
 		return err
 	}
-
-	if tcpLn, ok := ln.(*net.TCPListener); ok {
-		ln = tcpKeepAliveListener{TCPListener: tcpLn}
-	}
-
-	return c.Serve(ln)
+	return c.serveAll(lns)
 }
 
-func (c *Core) prefork() error {
-	var (
-		ln  net.Listener
-		err error
-	)
-	if IsChild() {
-		// use 1 cpu core per child process
-		runtime.GOMAXPROCS(1)
-		if ln, err = reuseport.Listen(c.networkProto, c.addr); err != nil {
-			time.Sleep(sleepDuration)
-			return fmt.Errorf("prefork: %w", err)
-		}
-		// kill current child proc when master exited
-		go watchMaster()
-
-		return c.Serve(ln)
+// serveAll serve every listener, the first error stops them all
+func (c *Core) serveAll(lns []net.Listener) error {
+	if len(lns) == 1 {
+		return c.Serve(c.wrapListener(lns[0]))
 	}
-
-	type child struct {
-		pid int
-		err error
+	errs := make(chan error, len(lns))
+	for _, ln := range lns {
+		ln = c.wrapListener(ln)
+		go func(ln net.Listener) {
+			errs <- c.Serve(ln)
+		}(ln)
 	}
-	// create variables
-	max := runtime.GOMAXPROCS(0)
-	childs := make(map[int]*exec.Cmd)
-	channel := make(chan child, max)
-
-	// kill child procs when master exists
-	defer func() {
-		for _, proc := range childs {
-			if err := proc.Process.Kill(); err != nil {
-				if !errors.Is(err, os.ErrProcessDone) {
-					log.Printf("prefork: failed to kill child: %v\n", err)
-				}
-			}
-		}
-	}()
-
-	var pids []string
-	for i := 0; i < max; i++ {
-		cmd := exec.Command(os.Args[0], os.Args[1:]...) // nolint:gosec
-		cmd.Stdout = os.Stdout
-		cmd.Stderr = os.Stderr
-
-		cmd.Env = append(os.Environ(),
-			fmt.Sprintf("%s=%s", envPreforkChildKey, envPreforkChildVal))
-		if err = cmd.Start(); err != nil {
-			return fmt.Errorf("failed to start a child prefork process, error: %w", err)
-		}
-
-		// store child process
-		pid := cmd.Process.Pid
-		childs[pid] = cmd
-		pids = append(pids, strconv.Itoa(pid))
-
-		go func() {
-			channel <- child{pid, cmd.Wait()}
-		}()
+	err := <-errs
+	if !errors.Is(err, http.ErrServerClosed) {
+		c.Server.Close()
 	}
-
-	Info("start childs %s", strings.Join(pids, ","))
-
-	return (<-channel).err
+	return err
 }
+
 func (c *Core) Serve(ln net.Listener) error {
 	port := strings.TrimPrefix(ln.Addr().String(), "[::]")
-	if !strings.Contains(ln.Addr().String(), "127.0.0.1") {
-		D("Listen: http://127.0.0.1%s\n", port)
+	scheme := "http"
+	if _, ok := ln.(tlsListener); ok {
+		scheme = "https"
+	}
+	if ln.Addr().Network() == "unix" {
+		D("Listen: %s+unix://%s\n", scheme, ln.Addr().String())
+	} else if !strings.Contains(ln.Addr().String(), "127.0.0.1") {
+		D("Listen: %s://127.0.0.1%s\n", scheme, port)
 
 		localIP, err := LocalIP()
 		if err == nil {
-			D("Listen: http://%s%s\n", localIP.String(), port)
+			D("Listen: %s://%s%s\n", scheme, localIP.String(), port)
 		}
 	} else {
-		D("Listen: http://%s\n", port)
+		D("Listen: %s://%s\n", scheme, port)
 	}
-	return c.Server.Serve(ln)
-}
-
-func (c *Core) Shutdown() error {
-	// TODO: 需要修正 GoServe 退出请求
-	return c.Server.Shutdown(context.TODO())
+	notifyReady()
+	err := c.Server.Serve(ln)
+	if errors.Is(err, http.ErrServerClosed) {
+		c.waitShutdown()
+	}
+	return err
 }
 
 // SetFuncMap sets the FuncMap used for template.FuncMap.
@@ -569,8 +603,11 @@ func LocalIP() (ip net.IP, err error) {
 	return addr.IP, nil
 }
 
+// tcpKeepAliveListener enable keep-alive on accepted connections, period 0
+// keeps the system default and a negative one disables it
 type tcpKeepAliveListener struct {
 	*net.TCPListener
+	period time.Duration
 }
 
 func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
@@ -578,8 +615,15 @@ func (ln tcpKeepAliveListener) Accept() (net.Conn, error) {
 	if err != nil {
 		return nil, err
 	}
-	if err = tc.SetKeepAlive(true); err != nil {
+	if err = tc.SetKeepAlive(ln.period >= 0); err != nil {
+		tc.Close()
 		return nil, err
 	}
+	if ln.period > 0 {
+		if err = tc.SetKeepAlivePeriod(ln.period); err != nil {
+			tc.Close()
+			return nil, err
+		}
+	}
 	return tc, err
 }
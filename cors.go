@@ -0,0 +1,233 @@
+package core
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CORSConfig policy of the CORS middleware
+//
+//	cors:
+//	  origins:                  # see matchOrigin
+//	    - https://app.example.com
+//	    - https://*.example.com
+//	  methods: [GET, POST, PUT, DELETE]   # default GET, HEAD, POST, PUT, PATCH, DELETE
+//	  headers: [Content-Type, Authorization] # default the requested ones
+//	  expose: [X-Request-Id]
+//	  credentials: true
+//	  max_age: 10m
+type CORSConfig struct {
+	AllowOrigins     []string
+	AllowOriginFunc  func(origin string) bool // used instead of AllowOrigins when set
+	AllowMethods     []string
+	AllowHeaders     []string
+	ExposeHeaders    []string
+	AllowCredentials bool
+	MaxAge           time.Duration
+}
+
+// NewCORSConfig returns the CORSConfig of the cors section
+func NewCORSConfig(conf Options) CORSConfig {
+	return CORSConfig{
+		AllowOrigins:     conf.GetStrings("origins", []string{"*"}),
+		AllowMethods:     conf.GetStrings("methods"),
+		AllowHeaders:     conf.GetStrings("headers"),
+		ExposeHeaders:    conf.GetStrings("expose"),
+		AllowCredentials: conf.GetBool("credentials"),
+		MaxAge:           conf.GetDuration("max_age"),
+	}
+}
+
+// corsPolicy a CORSConfig ready to serve
+type corsPolicy struct {
+	CORSConfig
+	anyOrigin bool
+	methods   string
+	headers   string
+	expose    string
+	maxAge    string
+}
+
+func newCORSPolicy(conf CORSConfig) *corsPolicy {
+	p := &corsPolicy{CORSConfig: conf}
+	for _, o := range conf.AllowOrigins {
+		if o == "*" {
+			p.anyOrigin = true
+		}
+	}
+	methods := conf.AllowMethods
+	if len(methods) == 0 {
+		methods = []string{MethodGet, MethodHead, MethodPost, MethodPut, MethodPatch, MethodDelete}
+	}
+	p.methods = strings.ToUpper(strings.Join(methods, ", "))
+	p.headers = strings.Join(conf.AllowHeaders, ", ")
+	p.expose = strings.Join(conf.ExposeHeaders, ", ")
+	if conf.MaxAge > 0 {
+		p.maxAge = strconv.Itoa(int(conf.MaxAge / time.Second))
+	}
+	return p
+}
+
+func (p *corsPolicy) allowOrigin(origin string) bool {
+	if p.AllowOriginFunc != nil {
+		return p.AllowOriginFunc(origin)
+	}
+	if p.anyOrigin {
+		return true
+	}
+	for _, o := range p.AllowOrigins {
+		if matchOrigin(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// apply set the CORS headers of the request, replacing those of a policy
+// applied before
+func (p *corsPolicy) apply(c *Ctx, preflight bool) {
+	h := c.W.Header()
+	for _, k := range []string{
+		HeaderAccessControlAllowOrigin, HeaderAccessControlAllowCredentials,
+		HeaderAccessControlExposeHeaders, HeaderAccessControlAllowMethods,
+		HeaderAccessControlAllowHeaders, HeaderAccessControlMaxAge,
+	} {
+		h.Del(k)
+	}
+	origin := c.GetHeader(HeaderOrigin)
+	if !p.anyOrigin || p.AllowCredentials || p.AllowOriginFunc != nil {
+		addVary(h, HeaderOrigin)
+	}
+	if origin == "" || !p.allowOrigin(origin) {
+		return
+	}
+	if p.anyOrigin && !p.AllowCredentials && p.AllowOriginFunc == nil {
+		h.Set(HeaderAccessControlAllowOrigin, "*")
+	} else {
+		h.Set(HeaderAccessControlAllowOrigin, origin)
+	}
+	if p.AllowCredentials {
+		h.Set(HeaderAccessControlAllowCredentials, "true")
+	}
+	if !preflight {
+		if p.expose != "" {
+			h.Set(HeaderAccessControlExposeHeaders, p.expose)
+		}
+		return
+	}
+	addVary(h, HeaderAccessControlRequestMethod)
+	addVary(h, HeaderAccessControlRequestHeaders)
+	h.Set(HeaderAccessControlAllowMethods, p.methods)
+	if headers := p.headers; headers != "" {
+		h.Set(HeaderAccessControlAllowHeaders, headers)
+	} else if req := c.GetHeader(HeaderAccessControlRequestHeaders); req != "" {
+		h.Set(HeaderAccessControlAllowHeaders, req)
+	}
+	if p.maxAge != "" {
+		h.Set(HeaderAccessControlMaxAge, p.maxAge)
+	}
+}
+
+func addVary(h http.Header, key string) {
+	for _, v := range h.Values(HeaderVary) {
+		for _, k := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(k), key) {
+				return
+			}
+		}
+	}
+	h.Add(HeaderVary, key)
+}
+
+// CORS middleware answer preflight requests without calling the handler
+// or the other middlewares of the route, as auth, and set the CORS headers
+// of the other requests. Without conf the policy is read from the cors
+// section.
+//
+// The policy of the most specific path wins, so a group or a Handler may
+// override the global one:
+//
+//	app.Use(core.CORS())
+//	app.Use("/public", core.CORS(core.CORSConfig{AllowOrigins: []string{"*"}}))
+//
+// A Handler with a CORSPolicy method gets its policy on its prefix.
+func CORS(conf ...CORSConfig) CORSHandler {
+	var (
+		once   sync.Once
+		policy *corsPolicy
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				policy = newCORSPolicy(conf[0])
+			} else {
+				policy = newCORSPolicy(NewCORSConfig(c.Core().Conf.GetMap("cors")))
+			}
+		})
+		policy.apply(c, isPreflight(c.R))
+	}
+}
+
+// CORSHandler a CORS policy, the ones given to Use also answer the
+// preflights of their path
+type CORSHandler HandlerFunc
+
+// methodCORS the slot of the tree keeping the CORS policies of a path
+const methodCORS = "CORS"
+
+// corsPolicies record the CORS policies given to Use for the preflights and
+// returns handler with them as HandlerFunc
+func (c *Core) corsPolicies(methods []string, path string, handler interface{}) interface{} {
+	switch h := handler.(type) {
+	case CORSHandler:
+		for _, m := range methods {
+			if m == MethodUse {
+				c.tree.Insert([]string{methodCORS}, path, HandlerFunc(h))
+			}
+		}
+		return HandlerFunc(h)
+	case []interface{}:
+		out := make([]interface{}, len(h))
+		for i, v := range h {
+			out[i] = c.corsPolicies(methods, path, v)
+		}
+		return out
+	}
+	return handler
+}
+
+// corsHandler a Handler with its own CORS policy
+type corsHandler interface {
+	CORSPolicy() CORSConfig
+}
+
+func isPreflight(r *http.Request) bool {
+	return r.Method == MethodOptions && r.Header.Get(HeaderOrigin) != "" &&
+		r.Header.Get(HeaderAccessControlRequestMethod) != ""
+}
+
+// preflight answer a CORS preflight with the CORS policies of the requested
+// route, false when it has none. The other middlewares, as auth or rate
+// limits, are not run, a preflight carries no credentials.
+func (c *Core) preflight(ctx *Ctx) bool {
+	method := strings.ToUpper(ctx.GetHeader(HeaderAccessControlRequestMethod))
+	result, err := c.tree.Find(method, ctx.R.URL.Path)
+	if err != nil {
+		return false
+	}
+	if len(result.cors) == 0 {
+		return false
+	}
+	ctx.params = result.params
+	ctx.handlers = result.cors
+	ctx.Next()
+	ctx.handlers = nil
+	if !ctx.W.Written() {
+		ctx.Status(StatusNoContent)
+		ctx.W.DoWriteHeader()
+	}
+	return true
+}
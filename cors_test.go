@@ -0,0 +1,100 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type corsTestHandler struct {
+	Handler
+}
+
+func (h *corsTestHandler) CORSPolicy() CORSConfig {
+	return CORSConfig{AllowOrigins: []string{"https://partner.org"}}
+}
+
+func (h *corsTestHandler) GetPartner(c *Ctx) {
+	c.SendString("partner")
+}
+
+func TestCORS(t *testing.T) {
+	app := New(Options{"cors": map[string]interface{}{
+		"origins":     []interface{}{"https://*.example.com"},
+		"credentials": true,
+		"max_age":     "10m",
+	}})
+	called := 0
+	app.Use(CORS())
+	app.Use("/public", CORS(CORSConfig{AllowOrigins: []string{"*"}, ExposeHeaders: []string{"X-Total"}}))
+	app.Use("/api", BasicAuth(BasicAuthConfig{Users: Users{"ann": "secret"}}))
+	app.Post("/api/items", func(c *Ctx) { called++; c.SendString("api") })
+	app.Get("/public/items", func(c *Ctx) { called++; c.SendString("public") })
+	app.Get("/route", CORS(CORSConfig{AllowOrigins: []string{"*"}}), func(c *Ctx) { c.SendString("route") })
+	h := &corsTestHandler{}
+	h.Prefix("/partner")
+	app.Use(h)
+
+	do := func(method, path, origin string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		req.Header.Set(HeaderOrigin, origin)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do(MethodOptions, "/api/items", "https://app.example.com",
+		HeaderAccessControlRequestMethod, "POST", HeaderAccessControlRequestHeaders, "Content-Type")
+	if w.Code != StatusNoContent || called != 0 {
+		t.Fatalf("preflight status %d, handler called %d times", w.Code, called)
+	}
+	if got := w.Header().Get(HeaderAccessControlAllowOrigin); got != "https://app.example.com" {
+		t.Fatalf("unexpected allow origin %q", got)
+	}
+	if w.Header().Get(HeaderAccessControlMaxAge) != "600" || w.Header().Get(HeaderAccessControlAllowHeaders) != "Content-Type" ||
+		w.Header().Get(HeaderAccessControlAllowCredentials) != "true" {
+		t.Fatalf("unexpected preflight headers %v", w.Header())
+	}
+
+	// the auth of the route does not see the preflight
+	w = do(MethodOptions, "/api/items", "https://app.example.com", HeaderAccessControlRequestMethod, "POST")
+	if w.Code != StatusNoContent || w.Header().Get(HeaderWWWAuthenticate) != "" ||
+		w.Header().Get(HeaderAccessControlAllowOrigin) != "https://app.example.com" {
+		t.Fatalf("preflight behind auth got %d %v", w.Code, w.Header())
+	}
+	if w = do(MethodPost, "/api/items", "https://app.example.com"); w.Code != StatusUnauthorized ||
+		w.Header().Get(HeaderAccessControlAllowOrigin) != "https://app.example.com" {
+		t.Fatalf("request without credentials got %d %v", w.Code, w.Header())
+	}
+
+	w = do(MethodPost, "/api/items", "https://evil.org", HeaderAuthorization, "Basic YW5uOnNlY3JldA==")
+	if w.Header().Get(HeaderAccessControlAllowOrigin) != "" || w.Body.String() != "api" {
+		t.Fatalf("disallowed origin got %v", w.Header())
+	}
+
+	// the group policy replaces the global one
+	w = do(MethodGet, "/public/items", "https://evil.org")
+	if w.Header().Get(HeaderAccessControlAllowOrigin) != "*" || w.Header().Get(HeaderAccessControlExposeHeaders) != "X-Total" ||
+		w.Header().Get(HeaderAccessControlAllowCredentials) != "" {
+		t.Fatalf("unexpected group headers %v", w.Header())
+	}
+
+	w = do(MethodGet, "/partner/partner", "https://partner.org")
+	if w.Header().Get(HeaderAccessControlAllowOrigin) != "https://partner.org" || w.Body.String() != "partner" {
+		t.Fatalf("unexpected handler policy %v %q", w.Header(), w.Body.String())
+	}
+	if w = do(MethodGet, "/partner/partner", "https://app.example.com"); w.Header().Get(HeaderAccessControlAllowOrigin) != "" {
+		t.Fatalf("handler policy not applied %v", w.Header())
+	}
+
+	if w = do(MethodGet, "/route", "https://evil.org"); w.Header().Get(HeaderAccessControlAllowOrigin) != "*" || w.Body.String() != "route" {
+		t.Fatalf("route policy got %v %q", w.Header(), w.Body.String())
+	}
+
+	if w = do(MethodOptions, "/missing", "https://app.example.com", HeaderAccessControlRequestMethod, "GET"); w.Code != http.StatusNotFound {
+		t.Fatalf("preflight of a missing route got %d", w.Code)
+	}
+}
@@ -0,0 +1,221 @@
+package core
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"html/template"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	csrfTokenKey = "csrf_token"
+	csrfFieldKey = "csrf_field"
+)
+
+var ErrCSRF = NewError(StatusForbidden, "csrf: invalid token or origin")
+
+// CSRF token modes
+const (
+	CSRFCookie  = "cookie"  // double submit, the token is in a cookie
+	CSRFSession = "session" // the token is in the session, needs the Session middleware
+)
+
+// CSRFConfig options of the CSRF middleware
+//
+//	csrf:
+//	  mode: cookie               # cookie or session, default cookie
+//	  cookie: _csrf              # cookie name of the cookie mode
+//	  header: X-CSRF-Token       # header the token is read from
+//	  field: _csrf               # form field the token is read from
+//	  max_age: 12h               # cookie lifetime, default 12h
+//	  exempt:                    # paths without checks, * matches the rest
+//	    - /webhooks/*
+//	  origins:                   # trusted origins besides the request host
+//	    - https://admin.example.com
+type CSRFConfig struct {
+	Mode           string
+	CookieName     string
+	Header         string
+	Field          string
+	MaxAge         time.Duration
+	Exempt         []string
+	TrustedOrigins []string
+}
+
+// NewCSRFConfig returns the CSRFConfig of the csrf section
+func NewCSRFConfig(conf Options) CSRFConfig {
+	return CSRFConfig{
+		Mode:           conf.GetString("mode", CSRFCookie),
+		CookieName:     conf.GetString("cookie", "_csrf"),
+		Header:         conf.GetString("header", "X-CSRF-Token"),
+		Field:          conf.GetString("field", "_csrf"),
+		MaxAge:         conf.GetDuration("max_age", 12*time.Hour),
+		Exempt:         conf.GetStrings("exempt"),
+		TrustedOrigins: conf.GetStrings("origins"),
+	}
+}
+
+// CSRF middleware protect unsafe methods against cross site requests: the
+// Origin or Referer must be the request host or a trusted origin, and the
+// token of the header or form field must match the one of the cookie or the
+// session. Without conf it is configured by the csrf section.
+//
+// Templates render the token with csrfField, the binding being the vars of
+// the Ctx or the token:
+//
+//	<form method="post">{{ csrfField . }}</form>
+func CSRF(conf ...CSRFConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  CSRFConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewCSRFConfig(c.Core().Conf.GetMap("csrf"))
+			}
+			if cfg.CookieName == "" {
+				cfg.CookieName = "_csrf"
+			}
+			if cfg.Header == "" {
+				cfg.Header = "X-CSRF-Token"
+			}
+			if cfg.Field == "" {
+				cfg.Field = "_csrf"
+			}
+		})
+		token := cfg.token(c)
+		if token == "" {
+			token = cfg.newToken(c)
+		}
+		c.Set(csrfTokenKey, token)
+		c.Set(csrfFieldKey, cfg.Field)
+
+		switch c.Method() {
+		case MethodGet, MethodHead, MethodOptions, MethodTrace:
+			return
+		}
+		if cfg.exempt(c.Path()) {
+			return
+		}
+		if !cfg.sameOrigin(c) {
+			c.Abort().SendStatus(ErrCSRF.Code, ErrCSRF.Message)
+			return
+		}
+		sent := c.GetHeader(cfg.Header)
+		if sent == "" {
+			sent = c.R.PostFormValue(cfg.Field)
+		}
+		if sent == "" || subtle.ConstantTimeCompare([]byte(sent), []byte(token)) != 1 {
+			c.Abort().SendStatus(ErrCSRF.Code, ErrCSRF.Message)
+		}
+	}
+}
+
+// token returns the token of the cookie or the session
+func (cfg *CSRFConfig) token(c *Ctx) string {
+	if cfg.Mode == CSRFSession {
+		t, _ := cfg.session(c).Get(csrfTokenKey).(string)
+		return t
+	}
+	if ck, err := c.R.Cookie(cfg.CookieName); err == nil {
+		return ck.Value
+	}
+	return ""
+}
+
+// newToken create a token and keep it in the cookie or the session
+func (cfg *CSRFConfig) newToken(c *Ctx) string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+	if cfg.Mode == CSRFSession {
+		cfg.session(c).Set(csrfTokenKey, token)
+		return token
+	}
+	// readable by scripts which send it back in the header
+	c.setCookie(cfg.CookieName, token, CookieOptions{
+		MaxAge: int(cfg.MaxAge / time.Second),
+		Secure: c.Scheme() == "https",
+	})
+	return token
+}
+
+func (cfg *CSRFConfig) session(c *Ctx) *SessionState {
+	s := c.Session()
+	if s == nil {
+		panic("csrf: the session mode needs the Session middleware before CSRF")
+	}
+	return s
+}
+
+func (cfg *CSRFConfig) exempt(path string) bool {
+	for _, p := range cfg.Exempt {
+		if p == path || (strings.HasSuffix(p, "*") && strings.HasPrefix(path, strings.TrimSuffix(p, "*"))) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameOrigin check the Origin, else the Referer, against the request host
+// and the trusted origins. Without either only plain http requests pass,
+// browsers send them for https.
+func (cfg *CSRFConfig) sameOrigin(c *Ctx) bool {
+	origin := c.GetHeader(HeaderOrigin)
+	if origin == "" || origin == "null" {
+		ref := c.GetHeader(HeaderReferer)
+		if ref == "" {
+			return origin == "" && c.Scheme() != "https"
+		}
+		u, err := url.Parse(ref)
+		if err != nil || u.Host == "" {
+			return false
+		}
+		origin = u.Scheme + "://" + u.Host
+	}
+	if strings.EqualFold(origin, c.Scheme()+"://"+c.Host()) {
+		return true
+	}
+	for _, pattern := range cfg.TrustedOrigins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// CSRFToken returns the token of the CSRF middleware
+func (c *Ctx) CSRFToken() string {
+	return c.GetString(csrfTokenKey)
+}
+
+// csrfField render the hidden input of the CSRF token, binding is the vars
+// of a Ctx or the token
+func csrfField(binding interface{}) template.HTML {
+	token, field := "", "_csrf"
+	switch b := binding.(type) {
+	case string:
+		token = b
+	case map[string]interface{}:
+		token, _ = b[csrfTokenKey].(string)
+		if f, ok := b[csrfFieldKey].(string); ok {
+			field = f
+		}
+	case Map:
+		token, _ = b[csrfTokenKey].(string)
+		if f, ok := b[csrfFieldKey].(string); ok {
+			field = f
+		}
+	}
+	return template.HTML(`<input type="hidden" name="` + template.HTMLEscapeString(field) +
+		`" value="` + template.HTMLEscapeString(token) + `">`)
+}
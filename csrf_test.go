@@ -0,0 +1,61 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCSRF(t *testing.T) {
+	for _, mode := range []string{CSRFCookie, CSRFSession} {
+		app := New()
+		if mode == CSRFSession {
+			app.Use(Session())
+		}
+		app.Use(CSRF(CSRFConfig{Mode: mode, Exempt: []string{"/hooks/*"}}))
+		app.Get("/form", func(c *Ctx) { c.SendString(string(csrfField(c.Vars()))) })
+		app.Post("/form", func(c *Ctx) { c.SendString("saved") })
+		app.Post("/hooks/github", func(c *Ctx) { c.SendString("hook") })
+
+		do := func(method, path string, body url.Values, cookies []*http.Cookie, headers ...string) *httptest.ResponseRecorder {
+			req := httptest.NewRequest(method, "http://example.com"+path, strings.NewReader(body.Encode()))
+			req.Header.Set(HeaderContentType, MIMEApplicationForm)
+			for _, ck := range cookies {
+				req.AddCookie(ck)
+			}
+			for i := 0; i+1 < len(headers); i += 2 {
+				req.Header.Set(headers[i], headers[i+1])
+			}
+			w := httptest.NewRecorder()
+			app.ServeHTTP(w, req)
+			return w
+		}
+
+		w := do("GET", "/form", nil, nil)
+		cookies := w.Result().Cookies()
+		field := w.Body.String()
+		i := strings.Index(field, `value="`)
+		if len(cookies) == 0 || i < 0 || !strings.Contains(field, `name="_csrf"`) {
+			t.Fatalf("%s: no token %q %v", mode, field, cookies)
+		}
+		token := strings.TrimSuffix(field[i+len(`value="`):], `">`)
+
+		if w = do("POST", "/form", url.Values{"_csrf": {token}}, cookies, HeaderOrigin, "http://example.com"); w.Body.String() != "saved" {
+			t.Fatalf("%s: form token refused %d", mode, w.Code)
+		}
+		if w = do("POST", "/form", nil, cookies, "X-CSRF-Token", token, HeaderReferer, "http://example.com/form"); w.Body.String() != "saved" {
+			t.Fatalf("%s: header token refused %d", mode, w.Code)
+		}
+		if w = do("POST", "/form", url.Values{"_csrf": {"forged"}}, cookies); w.Code != StatusForbidden {
+			t.Fatalf("%s: forged token got %d", mode, w.Code)
+		}
+		if w = do("POST", "/form", url.Values{"_csrf": {token}}, cookies, HeaderOrigin, "https://evil.org"); w.Code != StatusForbidden {
+			t.Fatalf("%s: cross origin got %d", mode, w.Code)
+		}
+		if w = do("POST", "/hooks/github", nil, nil, HeaderOrigin, "https://github.com"); w.Body.String() != "hook" {
+			t.Fatalf("%s: exempt route got %d", mode, w.Code)
+		}
+	}
+}
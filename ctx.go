@@ -32,6 +32,7 @@ type Ctx struct {
 	Config   *Options
 	params   params
 	path     string
+	route    string
 	idx      int8
 	mu       sync.RWMutex
 	vars     map[string]interface{}
@@ -39,6 +40,7 @@ type Ctx struct {
 	sameSite http.SameSite
 	handlers HandlerFuncs
 	theme    string
+	session  *SessionState
 }
 
 func (c *Ctx) init(w http.ResponseWriter, r *http.Request, core *Core) {
@@ -47,10 +49,12 @@ func (c *Ctx) init(w http.ResponseWriter, r *http.Request, core *Core) {
 	c.W = &c.wm
 	c.Resp = w
 	c.path = r.URL.Path
+	c.route = ""
 	c.Context = r.Context()
 	c.params = make(params, 0)
 	c.idx = -1
 	c.handlers = nil
+	c.session = nil
 	c.core = core
 	c.sameSite = http.SameSiteDefaultMode
 	c.vars = make(map[string]interface{})
@@ -62,6 +66,11 @@ func (c *Ctx) Path() string {
 	return c.path
 }
 
+// Route returns the pattern of the matched route, as /users/:id
+func (c *Ctx) Route() string {
+	return c.route
+}
+
 func (c *Ctx) Method() string {
 	return c.R.Method
 }
@@ -611,67 +620,35 @@ func (c *Ctx) GetAs(key string, v interface{}) error {
 	return ErrDataTypeNotSupport
 }
 
-// RemoteIP parses the IP from Request.RemoteAddr, normalizes and returns the IP (without the port).
-// It also checks if the remoteIP is a trusted proxy or not.
-// In order to perform this validation, it will see if the IP is contained within at least one of the CIDR blocks
-func (c *Ctx) RemoteIP() net.IP {
-	remote := c.GetHeader("X-Forwarded-For")
-	remoteIP := net.ParseIP(remote)
-	if remoteIP != nil {
-		return remoteIP
-	}
-	remote = c.GetHeader("X-Real-IP")
-	remoteIP = net.ParseIP(remote)
-	if remoteIP != nil {
-		return remoteIP
-	}
-	ip, _, err := net.SplitHostPort(strings.TrimSpace(c.R.RemoteAddr))
-	if err != nil {
-		return nil
-	}
-	remoteIP = net.ParseIP(ip)
-	if remoteIP == nil {
-		return nil
-	}
-
-	return remoteIP
-}
-
 // Cookie
 
 // SetCookie adds a Set-Cookie header to the ResponseWriter's headers.
 // The provided cookie must have a valid Name. Invalid cookies may be
 // silently dropped.
+//
+// args are a domain, "httponly", a bool for secure or CookieOptions whose
+// set fields override the others.
+//
+//	c.SetCookie("theme", "dark", exp, "/", core.CookieOptions{MaxAge: 3600, SameSite: http.SameSiteStrictMode})
 func (c *Ctx) SetCookie(name, value string, exp time.Time, path string, args ...interface{}) {
-	if path == "" {
-		path = "/"
-	}
-	cookie := &http.Cookie{
-		Name:     name,
-		Value:    url.QueryEscape(value),
-		Expires:  exp,
-		Path:     path,
-		SameSite: http.SameSiteLaxMode,
-	}
-
+	opts := CookieOptions{Expires: exp, Path: path}
 	for _, arg := range args {
 		switch a := arg.(type) {
 		case string:
 			if strings.EqualFold(a, "httponly") {
-				cookie.HttpOnly = true
+				opts.HttpOnly = true
 				continue
 			}
-			cookie.Domain = a
+			opts.Domain = a
 		case bool:
-			cookie.Secure = a
+			opts.Secure = a
+		case CookieOptions:
+			opts.merge(a)
+		case *CookieOptions:
+			opts.merge(*a)
 		}
 	}
-
-	if cookie.Domain == "" { // read config domain
-		cookie.Domain = c.Core().Conf.GetString("domain")
-	}
-
-	http.SetCookie(c.W, cookie)
+	c.setCookie(name, url.QueryEscape(value), opts)
 }
 
 func (c *Ctx) RemoveCookie(name, path string, dom ...string) {
@@ -867,16 +844,29 @@ type resp struct {
 	http.ResponseWriter
 	size   int
 	status int
+	before []func()
 }
 
 func (w *resp) init(writer http.ResponseWriter) {
 	w.ResponseWriter = writer
 	w.size = -1
 	w.status = StatusOK
+	w.before = w.before[:0]
+}
+
+// beforeWriteHeader register fn to run once before the header is written,
+// the last chance to set headers
+func (w *resp) beforeWriteHeader(fn func()) {
+	w.before = append(w.before, fn)
 }
 
 func (w *resp) DoWriteHeader() {
 	if !w.Written() {
+		for len(w.before) > 0 {
+			fn := w.before[0]
+			w.before = w.before[1:]
+			fn()
+		}
 		w.size = 0
 		w.ResponseWriter.WriteHeader(w.status)
 	}
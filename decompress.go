@@ -0,0 +1,146 @@
+package core
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+)
+
+// DecompressConfig options of the Decompress middleware
+//
+//	decompress:
+//	  max_size: 10485760      # decoded body, default 10MB
+//	  max_raw_size: 1048576   # body as sent, as BodyLimit, 0 keeps max_body_bytes
+//	  encodings: [gzip, deflate, br]
+type DecompressConfig struct {
+	MaxSize    int64
+	MaxRawSize int64
+	Encodings  []string
+}
+
+// NewDecompressConfig returns the DecompressConfig of the decompress section
+func NewDecompressConfig(conf Options) DecompressConfig {
+	return DecompressConfig{
+		MaxSize:    conf.GetInt64("max_size", 10<<20),
+		MaxRawSize: conf.GetInt64("max_raw_size"),
+		Encodings:  conf.GetStrings("encodings", []string{EncodingGzip, "deflate", EncodingBrotli}),
+	}
+}
+
+// Decompress middleware decode request bodies sent with a Content-Encoding,
+// the handlers read the plain body. A decoded body over MaxSize, as of a zip
+// bomb, or a sent one over MaxRawSize gets 413, an unknown encoding 415.
+// Without conf it is configured by the decompress section.
+//
+//	app.Post("/sync", core.Decompress(core.DecompressConfig{MaxSize: 32 << 20, MaxRawSize: 4 << 20}), sync)
+func Decompress(conf ...DecompressConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  DecompressConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewDecompressConfig(c.Core().Conf.GetMap("decompress"))
+			}
+			if cfg.MaxSize <= 0 {
+				cfg.MaxSize = 10 << 20
+			}
+			if len(cfg.Encodings) == 0 {
+				cfg.Encodings = []string{EncodingGzip, "deflate", EncodingBrotli}
+			}
+		})
+		if cfg.MaxRawSize > 0 && !limitRawBody(c, cfg.MaxRawSize) {
+			return
+		}
+		encoding := strings.ToLower(strings.TrimSpace(c.GetHeader(HeaderContentEncoding)))
+		if encoding == "" || encoding == "identity" || c.R.Body == nil || c.R.Body == http.NoBody {
+			return
+		}
+		accepted := false
+		for _, e := range cfg.Encodings {
+			accepted = accepted || e == encoding
+		}
+		var dec io.Reader
+		var err error
+		if accepted {
+			dec, err = newDecoder(encoding, c.R.Body)
+		}
+		if !accepted || dec == nil {
+			// RFC 7694, the encodings the server understands
+			c.SetHeader(HeaderAcceptEncoding, strings.Join(cfg.Encodings, ", "))
+			c.Abort().SendStatus(StatusUnsupportedMediaType, StatusMessage(StatusUnsupportedMediaType))
+			return
+		}
+		if err != nil {
+			if err == ErrRequestEntityTooLarge {
+				c.Abort().SendStatus(StatusRequestEntityTooLarge, StatusMessage(StatusRequestEntityTooLarge))
+			} else {
+				c.Abort().SendStatus(StatusBadRequest, StatusMessage(StatusBadRequest))
+			}
+			return
+		}
+		c.R.Body = &limitedBody{
+			ReadCloser: &decodedBody{Reader: dec, raw: c.R.Body},
+			limit:      cfg.MaxSize,
+			length:     -1,
+		}
+		c.R.ContentLength = -1
+		c.R.Header.Del(HeaderContentEncoding)
+		c.R.Header.Del(HeaderContentLength)
+	}
+}
+
+// newDecoder returns the decoder of an encoding, nil for unknown ones
+func newDecoder(encoding string, body io.Reader) (io.Reader, error) {
+	switch encoding {
+	case EncodingGzip, "x-gzip":
+		return gzip.NewReader(body)
+	case "deflate":
+		// zlib as the RFC says, raw deflate as some clients send
+		br := bufio.NewReader(body)
+		if head, err := br.Peek(2); err == nil && head[0]&0x0f == 8 && (uint16(head[0])<<8|uint16(head[1]))%31 == 0 {
+			return zlib.NewReader(br)
+		}
+		return flate.NewReader(br), nil
+	case EncodingBrotli:
+		return brotli.NewReader(body), nil
+	}
+	return nil, nil
+}
+
+// decodedBody the decoded request body, closing it closes the one sent
+type decodedBody struct {
+	io.Reader
+	raw io.ReadCloser
+}
+
+func (d *decodedBody) Read(p []byte) (int, error) {
+	n, err := d.Reader.Read(p)
+	if err != nil && d.rawTooLarge() {
+		err = ErrRequestEntityTooLarge
+	}
+	return n, err
+}
+
+func (d *decodedBody) Close() error {
+	if c, ok := d.Reader.(io.Closer); ok {
+		c.Close()
+	}
+	return d.raw.Close()
+}
+
+// rawTooLarge reports whether the body as sent went over its limit
+func (d *decodedBody) rawTooLarge() bool {
+	lb, ok := d.raw.(*limitedBody)
+	return ok && lb.exceeded
+}
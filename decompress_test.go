@@ -0,0 +1,81 @@
+package core
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+)
+
+func TestDecompress(t *testing.T) {
+	app := New()
+	app.Use(Decompress(DecompressConfig{MaxSize: 1 << 20, MaxRawSize: 64 << 10}))
+	app.Post("/", func(c *Ctx) {
+		var in struct {
+			Name string `json:"name"`
+		}
+		if err := c.ReadBody(&in); err != nil {
+			return // 413 when the body is too large
+		}
+		c.SendString(in.Name)
+	})
+	encode := func(enc string, raw []byte) []byte {
+		var buf bytes.Buffer
+		var w io.WriteCloser
+		switch enc {
+		case "gzip":
+			w = gzip.NewWriter(&buf)
+		case "deflate":
+			w = zlib.NewWriter(&buf)
+		case "raw-deflate":
+			w, _ = flate.NewWriter(&buf, flate.DefaultCompression)
+		case "br":
+			w = brotli.NewWriter(&buf)
+		}
+		w.Write(raw)
+		w.Close()
+		return buf.Bytes()
+	}
+	post := func(enc string, body []byte) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/", bytes.NewReader(body))
+		req.Header.Set(HeaderContentType, MIMEApplicationJSON)
+		if enc == "raw-deflate" {
+			req.Header.Set(HeaderContentEncoding, "deflate")
+		} else if enc != "" {
+			req.Header.Set(HeaderContentEncoding, enc)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	json := []byte(`{"name":"ann"}`)
+	for _, enc := range []string{"gzip", "deflate", "raw-deflate", "br"} {
+		if w := post(enc, encode(enc, json)); w.Body.String() != "ann" {
+			t.Fatalf("%s: got %d %q", enc, w.Code, w.Body.String())
+		}
+	}
+	if w := post("", json); w.Body.String() != "ann" {
+		t.Fatalf("plain body got %q", w.Body.String())
+	}
+
+	// 32MB of zeros compress to a few KB
+	bomb := encode("gzip", append([]byte(`{"name":"`), make([]byte, 32<<20)...))
+	if w := post("gzip", bomb); w.Code != StatusRequestEntityTooLarge {
+		t.Fatalf("zip bomb got %d", w.Code)
+	}
+	if w := post("", make([]byte, 128<<10)); w.Code != StatusRequestEntityTooLarge {
+		t.Fatalf("raw body over the limit got %d", w.Code)
+	}
+	if w := post("compress", json); w.Code != StatusUnsupportedMediaType || w.Header().Get(HeaderAcceptEncoding) == "" {
+		t.Fatalf("unknown encoding got %d", w.Code)
+	}
+	if w := post("gzip", json); w.Code != StatusBadRequest {
+		t.Fatalf("invalid gzip got %d", w.Code)
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/base64"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotModified the request was answered with 304 by its validators
+var ErrNotModified = NewError(StatusNotModified) // RFC 7232, 4.1
+
+const etagExplicitKey = "etag_explicit"
+
+// ETagConfig options of the ETag middleware
+//
+//	etag:
+//	  weak: false        # W/ tags, the body may change by its encoding
+//	  max_size: 1048576  # larger bodies are sent as they are, without a tag
+type ETagConfig struct {
+	Weak    bool
+	MaxSize int
+}
+
+// NewETagConfig returns the ETagConfig of the etag section
+func NewETagConfig(conf Options) ETagConfig {
+	return ETagConfig{
+		Weak:    conf.GetBool("weak"),
+		MaxSize: conf.GetInt("max_size", 1<<20),
+	}
+}
+
+// ETag middleware tag the 200 responses of GET and HEAD by a hash of their
+// body and answer a matching If-None-Match or If-Modified-Since with 304.
+// The body is held until the handlers are done, a streamed or larger one is
+// sent as it is. Handlers knowing the version of the resource call c.ETag or
+// c.LastModified instead, nothing is hashed then, the ones of PUT, PATCH and
+// DELETE call them with the current version before changing it so a failed
+// If-Match or If-Unmodified-Since gets 412. Without conf it is configured by
+// the etag section.
+//
+//	app.Use(core.ETag())
+func ETag(conf ...ETagConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  ETagConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewETagConfig(c.Core().Conf.GetMap("etag"))
+			}
+			if cfg.MaxSize <= 0 {
+				cfg.MaxSize = 1 << 20
+			}
+		})
+		if c.Method() != MethodGet && c.Method() != MethodHead {
+			return
+		}
+		w := &etagWriter{ResponseWriter: c.W, max: cfg.MaxSize}
+		prev := c.W
+		c.W = w
+		c.Next()
+		c.W = prev
+		if w.passed {
+			return
+		}
+		h := prev.Header()
+		if _, explicit := c.Get(etagExplicitKey); !explicit && prev.Status() == StatusOK {
+			if h.Get(HeaderETag) == "" && len(w.buf) > 0 {
+				h.Set(HeaderETag, hashETag(w.buf, cfg.Weak))
+			}
+			if checkPreconditions(c.R, h.Get(HeaderETag), lastModified(h)) == StatusNotModified {
+				notModified(prev)
+				return
+			}
+		}
+		if len(w.buf) > 0 {
+			prev.Write(w.buf)
+		} else if w.header {
+			prev.DoWriteHeader()
+		}
+	}
+}
+
+// ETag set the ETag of the response, quoted when it is not, and check the
+// preconditions of the request against it. ErrNotModified and
+// ErrPreconditionFailed tell the request was answered, the handler returns.
+//
+//	if c.ETag(post.Version) != nil {
+//		return
+//	}
+func (c *Ctx) ETag(tag string, weak ...bool) error {
+	if !strings.HasPrefix(tag, `"`) && !strings.HasPrefix(tag, `W/"`) {
+		tag = `"` + tag + `"`
+	}
+	if len(weak) > 0 && weak[0] && !strings.HasPrefix(tag, "W/") {
+		tag = "W/" + tag
+	}
+	c.SetHeader(HeaderETag, tag)
+	return c.validate()
+}
+
+// LastModified set the Last-Modified of the response and check the
+// preconditions of the request against it, as ETag
+func (c *Ctx) LastModified(t time.Time) error {
+	c.SetHeader(HeaderLastModified, t.UTC().Format(http.TimeFormat))
+	return c.validate()
+}
+
+// validate answer the request when its preconditions fail against the
+// validators set by the handler
+func (c *Ctx) validate() error {
+	c.Set(etagExplicitKey, true)
+	h := c.W.Header()
+	switch checkPreconditions(c.R, h.Get(HeaderETag), lastModified(h)) {
+	case StatusNotModified:
+		c.Abort()
+		notModified(c.W)
+		return ErrNotModified
+	case StatusPreconditionFailed:
+		c.Abort().SendStatus(ErrPreconditionFailed.Code, ErrPreconditionFailed.Message)
+		return ErrPreconditionFailed
+	}
+	return nil
+}
+
+// checkPreconditions evaluate the conditional headers of the request as of
+// RFC 7232 6, a validator not known skips its conditions. It returns 304,
+// 412 or 0 when the request goes on.
+func checkPreconditions(r *http.Request, etag string, modified time.Time) int {
+	safe := r.Method == MethodGet || r.Method == MethodHead
+	if im := r.Header.Get(HeaderIfMatch); im != "" {
+		if (etag != "" || im == "*") && !matchETag(im, etag, false) {
+			return StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(r.Header.Get(HeaderIfUnmodifiedSince)); err == nil && !modified.IsZero() {
+		if modified.After(t) {
+			return StatusPreconditionFailed
+		}
+	}
+	if inm := r.Header.Get(HeaderIfNoneMatch); inm != "" {
+		if etag != "" && matchETag(inm, etag, true) {
+			if safe {
+				return StatusNotModified
+			}
+			return StatusPreconditionFailed
+		}
+	} else if t, err := http.ParseTime(r.Header.Get(HeaderIfModifiedSince)); err == nil && safe && !modified.IsZero() {
+		if !modified.After(t) {
+			return StatusNotModified
+		}
+	}
+	return 0
+}
+
+// matchETag reports whether a list of tags of a conditional header matches
+// etag, weak compares W/ tags as the strong ones
+func matchETag(list, etag string, weak bool) bool {
+	for _, tag := range strings.Split(list, ",") {
+		tag = strings.TrimSpace(tag)
+		switch {
+		case tag == "*":
+			return true
+		case weak:
+			if strings.TrimPrefix(tag, "W/") == strings.TrimPrefix(etag, "W/") {
+				return true
+			}
+		case tag == etag && !strings.HasPrefix(tag, "W/"):
+			return true
+		}
+	}
+	return false
+}
+
+// hashETag returns the tag of a body
+func hashETag(body []byte, weak bool) string {
+	sum := sha256.Sum256(body)
+	tag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// lastModified returns the Last-Modified of a response, zero without one
+func lastModified(h http.Header) time.Time {
+	t, _ := http.ParseTime(h.Get(HeaderLastModified))
+	return t
+}
+
+// notModified answer 304, the validators stay and the body headers go
+func notModified(w ResponseWriter) {
+	h := w.Header()
+	for _, k := range []string{HeaderContentType, HeaderContentLength, HeaderContentEncoding, HeaderTransferEncoding} {
+		h.Del(k)
+	}
+	w.WriteHeader(StatusNotModified)
+	w.DoWriteHeader()
+}
+
+// etagWriter a ResponseWriter holding the body until the handlers are done,
+// a streamed or too large body is passed through
+type etagWriter struct {
+	ResponseWriter
+	buf    []byte
+	max    int
+	passed bool
+	header bool // DoWriteHeader was called
+}
+
+// pass write the held body out, the rest goes through
+func (w *etagWriter) pass() {
+	if w.passed {
+		return
+	}
+	w.passed = true
+	if len(w.buf) > 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *etagWriter) Write(p []byte) (int, error) {
+	if !w.passed {
+		if len(w.buf)+len(p) <= w.max {
+			w.buf = append(w.buf, p...)
+			return len(p), nil
+		}
+		w.pass()
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+func (w *etagWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *etagWriter) Flush() {
+	w.pass()
+	w.ResponseWriter.Flush()
+}
+
+// DoWriteHeader is held as the body, a 304 answered by c.ETag goes out
+func (w *etagWriter) DoWriteHeader() {
+	if w.passed || w.Status() == StatusNotModified {
+		w.pass()
+		w.ResponseWriter.DoWriteHeader()
+		return
+	}
+	w.header = true
+}
+
+func (w *etagWriter) Size() int {
+	if !w.passed && len(w.buf) > 0 {
+		return len(w.buf)
+	}
+	return w.ResponseWriter.Size()
+}
+
+func (w *etagWriter) Written() bool {
+	return len(w.buf) > 0 || w.header || w.ResponseWriter.Written()
+}
+
+func (w *etagWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.pass()
+	return w.ResponseWriter.Hijack()
+}
@@ -0,0 +1,96 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestETag(t *testing.T) {
+	var version, puts int64 = 1, 0
+	modified := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	app := New()
+	app.Use(ETag())
+	app.Get("/posts", func(c *Ctx) {
+		c.JSON(Map{"version": atomic.LoadInt64(&version)})
+	})
+	app.Put("/posts", func(c *Ctx) {
+		if c.ETag("v"+strconv.FormatInt(atomic.LoadInt64(&version), 10)) != nil {
+			return
+		}
+		atomic.AddInt64(&puts, 1)
+		atomic.AddInt64(&version, 1)
+		c.SendStatus(StatusNoContent, "")
+	})
+	app.Get("/doc", func(c *Ctx) {
+		if c.LastModified(modified) != nil {
+			return
+		}
+		c.SendString("doc")
+	})
+	app.Delete("/doc", func(c *Ctx) {
+		if c.LastModified(modified) != nil {
+			return
+		}
+		c.SendStatus(StatusNoContent, "")
+	})
+	app.Get("/stream", func(c *Ctx) {
+		c.SendString("part")
+		c.W.Flush()
+	})
+	do := func(method, path string, headers ...string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, path, nil)
+		for i := 0; i+1 < len(headers); i += 2 {
+			req.Header.Set(headers[i], headers[i+1])
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	w := do("GET", "/posts")
+	etag := w.Header().Get(HeaderETag)
+	if w.Code != StatusOK || !strings.HasPrefix(etag, `"`) || w.Body.String() != `{"version":1}` {
+		t.Fatalf("got %d %q %q", w.Code, etag, w.Body.String())
+	}
+	if w := do("GET", "/posts", HeaderIfNoneMatch, `"x", W/`+etag); w.Code != StatusNotModified || w.Body.Len() != 0 || w.Header().Get(HeaderContentType) != "" {
+		t.Fatalf("If-None-Match got %d %q", w.Code, w.Body.String())
+	}
+	if w := do("GET", "/posts", HeaderIfNoneMatch, `"other"`); w.Code != StatusOK {
+		t.Fatalf("changed tag got %d", w.Code)
+	}
+
+	if w := do("PUT", "/posts", HeaderIfMatch, `"stale"`); w.Code != StatusPreconditionFailed || puts != 0 {
+		t.Fatalf("stale If-Match got %d after %d puts", w.Code, puts)
+	}
+	if w := do("PUT", "/posts", HeaderIfMatch, `"v1"`); w.Code != StatusNoContent || puts != 1 {
+		t.Fatalf("current If-Match got %d after %d puts", w.Code, puts)
+	}
+	if w := do("PUT", "/posts", HeaderIfMatch, `"v1"`); w.Code != StatusPreconditionFailed || puts != 1 {
+		t.Fatalf("lost update got %d after %d puts", w.Code, puts)
+	}
+	if w := do("PUT", "/posts", HeaderIfNoneMatch, "*"); w.Code != StatusPreconditionFailed {
+		t.Fatalf("create only got %d", w.Code)
+	}
+
+	since := modified.Format(http.TimeFormat)
+	if w := do("GET", "/doc", HeaderIfModifiedSince, since); w.Code != StatusNotModified || w.Header().Get(HeaderETag) != "" {
+		t.Fatalf("If-Modified-Since got %d %v", w.Code, w.Header())
+	}
+	if w := do("GET", "/doc", HeaderIfModifiedSince, modified.Add(-time.Hour).Format(http.TimeFormat)); w.Code != StatusOK || w.Body.String() != "doc" {
+		t.Fatalf("modified doc got %d %q", w.Code, w.Body.String())
+	}
+	if w := do("DELETE", "/doc", HeaderIfUnmodifiedSince, modified.Add(-time.Hour).Format(http.TimeFormat)); w.Code != StatusPreconditionFailed {
+		t.Fatalf("If-Unmodified-Since got %d", w.Code)
+	}
+	if w := do("DELETE", "/doc", HeaderIfUnmodifiedSince, since); w.Code != StatusNoContent {
+		t.Fatalf("unmodified doc got %d", w.Code)
+	}
+	if w := do("GET", "/stream"); w.Header().Get(HeaderETag) != "" || w.Body.String() != "part" {
+		t.Fatalf("stream got %v %q", w.Header(), w.Body.String())
+	}
+}
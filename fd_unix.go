@@ -0,0 +1,47 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"syscall"
+)
+
+// restoreNonblock put the listeners back in non-blocking mode, os/exec
+// switch the file description shared with the passed files to blocking and
+// a blocked accept would hang the shutdown.
+func restoreNonblock(lns []net.Listener) {
+	for _, ln := range lns {
+		sc, ok := ln.(syscall.Conn)
+		if !ok {
+			continue
+		}
+		if rc, err := sc.SyscallConn(); err == nil {
+			rc.Control(func(fd uintptr) {
+				syscall.SetNonblock(int(fd), true)
+			})
+		}
+	}
+}
+
+// socketpair returns the master end of a unix socketpair and the file
+// passed to a worker
+func socketpair() (net.Conn, *os.File, error) {
+	fds, err := syscall.Socketpair(syscall.AF_UNIX, syscall.SOCK_STREAM, 0)
+	if err != nil {
+		return nil, nil, fmt.Errorf("ipc: %w", err)
+	}
+	syscall.CloseOnExec(fds[0])
+	syscall.CloseOnExec(fds[1])
+	f := os.NewFile(uintptr(fds[0]), "ipc")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		syscall.Close(fds[1])
+		return nil, nil, fmt.Errorf("ipc: %w", err)
+	}
+	return conn, os.NewFile(uintptr(fds[1]), "ipc"), nil
+}
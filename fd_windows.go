@@ -0,0 +1,17 @@
+//go:build windows
+// +build windows
+
+package core
+
+import (
+	"net"
+	"os"
+)
+
+// restoreNonblock listeners are not passed on windows
+func restoreNonblock(lns []net.Listener) {}
+
+// socketpair windows can not pass sockets to workers
+func socketpair() (net.Conn, *os.File, error) {
+	return nil, nil, ErrIPCNotSupported
+}
@@ -0,0 +1,435 @@
+package core
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// HubPolicy decide what happens when a subscriber queue is full
+type HubPolicy int
+
+const (
+	// HubDropNewest discard the message being published (default)
+	HubDropNewest HubPolicy = iota
+	// HubDropOldest discard the oldest queued message to make room
+	HubDropOldest
+	// HubCloseSlow close the subscriber, slow clients get disconnected
+	HubCloseSlow
+)
+
+// HubConfig hub options
+type HubConfig struct {
+	// QueueSize buffered messages per subscriber. default 64
+	QueueSize int
+	// Policy applied when a subscriber queue is full.
+	Policy HubPolicy
+	// Heartbeat interval of the SSE keep-alive comment, negative disable. default 15s
+	Heartbeat time.Duration
+}
+
+// HubMessage a message delivered to subscribers
+type HubMessage struct {
+	Topic string
+	Event string // SSE event name, optional
+	Data  []byte
+}
+
+// Hub in-process pub/sub for fanning out messages to SSE and websocket clients
+//
+//	hub := core.NewHub()
+//	app.Get("/events", hub.ServeSSE("orders"))
+//	app.Get("/ws", hub.ServeWebSocket(core.WebSocketConfig{}, "orders"))
+//	hub.Publish("orders", "update", raw)
+type Hub struct {
+	conf   HubConfig
+	mu     sync.RWMutex
+	topics map[string]map[*Subscriber]struct{}
+	closed bool
+}
+
+// NewHub create hub
+func NewHub(conf ...HubConfig) *Hub {
+	h := &Hub{
+		topics: make(map[string]map[*Subscriber]struct{}),
+	}
+	if len(conf) > 0 {
+		h.conf = conf[0]
+	}
+	if h.conf.QueueSize <= 0 {
+		h.conf.QueueSize = 64
+	}
+	if h.conf.Heartbeat == 0 {
+		h.conf.Heartbeat = 15 * time.Second
+	}
+	return h
+}
+
+// Subscribe create a subscriber listening on topics
+func (h *Hub) Subscribe(topics ...string) *Subscriber {
+	s := &Subscriber{
+		hub:    h,
+		ch:     make(chan *HubMessage, h.conf.QueueSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]struct{}),
+	}
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+	if closed {
+		s.close()
+		return s
+	}
+	s.Subscribe(topics...)
+	return s
+}
+
+// SubscribeCtx subscribe topics for the lifetime of the request,
+// the subscriber is closed when c finishes or the client goes away.
+func (h *Hub) SubscribeCtx(c *Ctx, topics ...string) *Subscriber {
+	s := h.Subscribe(topics...)
+	go func(ctx context.Context) {
+		select {
+		case <-ctx.Done():
+			s.Close()
+		case <-s.done:
+		}
+	}(c.Context)
+	return s
+}
+
+// Publish send data to subscribers of topic, return the number of
+// subscribers the message was queued for.
+func (h *Hub) Publish(topic, event string, data []byte) int {
+	msg := &HubMessage{Topic: topic, Event: event, Data: data}
+	h.mu.RLock()
+	subs := make([]*Subscriber, 0, len(h.topics[topic]))
+	for s := range h.topics[topic] {
+		subs = append(subs, s)
+	}
+	h.mu.RUnlock()
+	return h.deliver(subs, msg)
+}
+
+// Broadcast send data to every subscriber whatever the topic.
+func (h *Hub) Broadcast(event string, data []byte) int {
+	msg := &HubMessage{Event: event, Data: data}
+	h.mu.RLock()
+	seen := make(map[*Subscriber]struct{})
+	subs := make([]*Subscriber, 0)
+	for _, ss := range h.topics {
+		for s := range ss {
+			if _, ok := seen[s]; !ok {
+				seen[s] = struct{}{}
+				subs = append(subs, s)
+			}
+		}
+	}
+	h.mu.RUnlock()
+	return h.deliver(subs, msg)
+}
+
+func (h *Hub) deliver(subs []*Subscriber, msg *HubMessage) int {
+	n := 0
+	for _, s := range subs {
+		if s.push(msg, h.conf.Policy) {
+			n++
+		}
+	}
+	return n
+}
+
+// Topics returns topics which have subscribers
+func (h *Hub) Topics() []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	topics := make([]string, 0, len(h.topics))
+	for t := range h.topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Count returns the number of subscribers of topic
+func (h *Hub) Count(topic string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.topics[topic])
+}
+
+// Close close all subscribers, later subscribers are closed immediately
+func (h *Hub) Close() {
+	h.mu.Lock()
+	h.closed = true
+	subs := make([]*Subscriber, 0)
+	for _, ss := range h.topics {
+		for s := range ss {
+			subs = append(subs, s)
+		}
+	}
+	h.topics = make(map[string]map[*Subscriber]struct{})
+	h.mu.Unlock()
+	for _, s := range subs {
+		s.close()
+	}
+}
+
+// Run block until ctx is done then close the hub
+func (h *Hub) Run(ctx context.Context) error {
+	<-ctx.Done()
+	h.Close()
+	return nil
+}
+
+func (h *Hub) add(s *Subscriber, topic string) bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if h.closed {
+		return false
+	}
+	ss, ok := h.topics[topic]
+	if !ok {
+		ss = make(map[*Subscriber]struct{})
+		h.topics[topic] = ss
+	}
+	ss[s] = struct{}{}
+	return true
+}
+
+func (h *Hub) remove(s *Subscriber, topics ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for _, t := range topics {
+		if ss, ok := h.topics[t]; ok {
+			delete(ss, s)
+			if len(ss) == 0 {
+				delete(h.topics, t)
+			}
+		}
+	}
+}
+
+// ServeSSE returns a HandlerFunc streaming messages of topics as server-sent events
+func (h *Hub) ServeSSE(topics ...string) HandlerFunc {
+	return func(c *Ctx) {
+		sub := h.SubscribeCtx(c, topics...)
+		defer sub.Close()
+		c.SetHeader(HeaderContentType, MIMETextEventStream)
+		c.SetHeader(HeaderCacheControl, "no-cache")
+		c.SetHeader(HeaderConnection, "keep-alive")
+		c.SetHeader("X-Accel-Buffering", "no")
+		c.W.Flush()
+
+		var heartbeat <-chan time.Time
+		if h.conf.Heartbeat > 0 {
+			ticker := time.NewTicker(h.conf.Heartbeat)
+			defer ticker.Stop()
+			heartbeat = ticker.C
+		}
+		buf := new(bytes.Buffer)
+		for {
+			select {
+			case msg, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				buf.Reset()
+				writeSSE(buf, msg)
+				if _, err := c.W.Write(buf.Bytes()); err != nil {
+					return
+				}
+			case <-heartbeat:
+				if _, err := c.W.WriteString(": ping\n\n"); err != nil {
+					return
+				}
+			case <-c.Done():
+				return
+			}
+			c.W.Flush()
+		}
+	}
+}
+
+func writeSSE(buf *bytes.Buffer, msg *HubMessage) {
+	if msg.Event != "" {
+		buf.WriteString("event: " + msg.Event + "\n")
+	}
+	for _, line := range bytes.Split(msg.Data, []byte{'\n'}) {
+		buf.WriteString("data: ")
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+	buf.WriteByte('\n')
+}
+
+// ServeWebSocket returns a HandlerFunc forwarding messages of topics to a
+// websocket client as text messages.
+func (h *Hub) ServeWebSocket(conf WebSocketConfig, topics ...string) HandlerFunc {
+	return WebSocket(func(conn *WSConn) {
+		sub := h.SubscribeCtx(conn.Ctx, topics...)
+		defer sub.Close()
+		go func() { // read until the client goes away
+			defer sub.Close()
+			for {
+				if _, _, err := conn.ReadMessage(); err != nil {
+					return
+				}
+			}
+		}()
+		for msg := range sub.C() {
+			if err := conn.WriteMessage(TextMessage, msg.Data); err != nil {
+				return
+			}
+		}
+	}, conf)
+}
+
+// Subscriber receive messages of its topics through C
+type Subscriber struct {
+	hub     *Hub
+	ch      chan *HubMessage
+	done    chan struct{}
+	mu      sync.Mutex
+	topics  map[string]struct{}
+	closed  bool
+	dropped uint64
+}
+
+// C returns the message queue, it is closed with the subscriber
+func (s *Subscriber) C() <-chan *HubMessage {
+	return s.ch
+}
+
+// Done is closed when the subscriber is closed
+func (s *Subscriber) Done() <-chan struct{} {
+	return s.done
+}
+
+// Dropped returns the number of messages dropped because the queue was full
+func (s *Subscriber) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Topics returns the subscribed topics
+func (s *Subscriber) Topics() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	topics := make([]string, 0, len(s.topics))
+	for t := range s.topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	return topics
+}
+
+// Subscribe add topics
+func (s *Subscriber) Subscribe(topics ...string) {
+	for _, t := range topics {
+		s.mu.Lock()
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		s.topics[t] = struct{}{}
+		s.mu.Unlock()
+		if !s.hub.add(s, t) {
+			s.close()
+			return
+		}
+	}
+}
+
+// Unsubscribe remove topics
+func (s *Subscriber) Unsubscribe(topics ...string) {
+	s.mu.Lock()
+	for _, t := range topics {
+		delete(s.topics, t)
+	}
+	s.mu.Unlock()
+	s.hub.remove(s, topics...)
+}
+
+// Close unsubscribe all topics and close C
+func (s *Subscriber) Close() {
+	s.mu.Lock()
+	topics := make([]string, 0, len(s.topics))
+	for t := range s.topics {
+		topics = append(topics, t)
+	}
+	s.mu.Unlock()
+	s.hub.remove(s, topics...)
+	s.close()
+}
+
+func (s *Subscriber) close() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed {
+		s.closed = true
+		close(s.ch)
+		close(s.done)
+	}
+}
+
+func (s *Subscriber) push(msg *HubMessage, policy HubPolicy) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	select {
+	case s.ch <- msg:
+		s.mu.Unlock()
+		return true
+	default:
+	}
+	atomic.AddUint64(&s.dropped, 1)
+	switch policy {
+	case HubDropOldest:
+		select {
+		case <-s.ch:
+		default:
+		}
+		select {
+		case s.ch <- msg:
+			s.mu.Unlock()
+			return true
+		default:
+		}
+	case HubCloseSlow:
+		s.mu.Unlock()
+		s.Close()
+		return false
+	}
+	s.mu.Unlock()
+	return false
+}
+
+// HubModule run a hub inside Engine, the hub is closed when the engine stops
+//
+//	hub := core.NewHub()
+//	core.RegisterModule(&core.HubModule{Hub: hub})
+type HubModule struct {
+	*Hub
+}
+
+func (m *HubModule) Module() ModuleInfo {
+	return ModuleInfo{
+		ID: "module.core.hub",
+		Instance: func() Mod {
+			return m
+		},
+	}
+}
+
+func (m *HubModule) Init() {}
+
+func (m *HubModule) Start(e *Engine) error {
+	// close the streams when shutdown starts, they would block the drain
+	e.Server.RegisterOnShutdown(m.Close)
+	return m.Run(e.Ctx)
+}
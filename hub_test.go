@@ -0,0 +1,177 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestHubPublish(t *testing.T) {
+	hub := NewHub(HubConfig{QueueSize: 1})
+	a := hub.Subscribe("orders")
+	b := hub.Subscribe("orders", "users")
+
+	if n := hub.Publish("orders", "update", []byte("1")); n != 2 {
+		t.Fatalf("expected 2 deliveries, got %d", n)
+	}
+	if n := hub.Publish("orders", "update", []byte("2")); n != 0 {
+		t.Fatalf("expected full queues to drop, got %d deliveries", n)
+	}
+	if msg := <-a.C(); string(msg.Data) != "1" || a.Dropped() != 1 {
+		t.Fatalf("unexpected message %q dropped %d", msg.Data, a.Dropped())
+	}
+
+	b.Unsubscribe("orders")
+	if hub.Count("orders") != 1 || hub.Count("users") != 1 {
+		t.Fatalf("unexpected counts orders=%d users=%d", hub.Count("orders"), hub.Count("users"))
+	}
+	a.Close()
+	if _, ok := <-a.C(); ok {
+		t.Fatalf("expected closed queue")
+	}
+	if topics := hub.Topics(); len(topics) != 1 || topics[0] != "users" {
+		t.Fatalf("unexpected topics %v", topics)
+	}
+	hub.Close()
+	select {
+	case <-b.Done():
+	default:
+		t.Fatalf("expected subscriber closed with hub")
+	}
+}
+
+func TestHubPolicies(t *testing.T) {
+	hub := NewHub(HubConfig{QueueSize: 1, Policy: HubDropOldest})
+	s := hub.Subscribe("t")
+	hub.Publish("t", "", []byte("old"))
+	hub.Publish("t", "", []byte("new"))
+	if msg := <-s.C(); string(msg.Data) != "new" {
+		t.Fatalf("expected newest message, got %q", msg.Data)
+	}
+
+	hub = NewHub(HubConfig{QueueSize: 1, Policy: HubCloseSlow})
+	s = hub.Subscribe("t")
+	hub.Publish("t", "", []byte("1"))
+	hub.Publish("t", "", []byte("2"))
+	<-s.Done()
+	if hub.Count("t") != 0 {
+		t.Fatalf("slow subscriber not removed")
+	}
+}
+
+func TestWriteSSE(t *testing.T) {
+	buf := new(bytes.Buffer)
+	writeSSE(buf, &HubMessage{Event: "update", Data: []byte("a\nb")})
+	if got := buf.String(); got != "event: update\ndata: a\ndata: b\n\n" {
+		t.Fatalf("unexpected event %q", got)
+	}
+}
+
+func TestHubSubscribeCtx(t *testing.T) {
+	hub := NewHub()
+	ctx, cancel := context.WithCancel(context.Background())
+	s := hub.SubscribeCtx(&Ctx{Context: ctx}, "orders")
+	if hub.Count("orders") != 1 {
+		t.Fatalf("not subscribed")
+	}
+	cancel()
+	select {
+	case <-s.Done():
+	case <-time.After(time.Second):
+		t.Fatal("subscriber not closed with its ctx")
+	}
+	if hub.Count("orders") != 0 {
+		t.Fatalf("subscriber not removed")
+	}
+}
+
+func TestHubServeSSE(t *testing.T) {
+	hub := NewHub(HubConfig{Heartbeat: 50 * time.Millisecond})
+	app := New(Options{"listen": "127.0.0.1:0"})
+	app.Get("/events", hub.ServeSSE("orders"))
+	if err := app.GoListenAndServeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown()
+	waitCount := func(n int) {
+		for i := 0; hub.Count("orders") != n; i++ {
+			if i == 100 {
+				t.Fatalf("%d subscribers, want %d", hub.Count("orders"), n)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	resp, err := http.Get("http://" + app.listeners[0].ln.Addr().String() + "/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ct := resp.Header.Get(HeaderContentType); !strings.HasPrefix(ct, MIMETextEventStream) {
+		t.Fatalf("content type %q", ct)
+	}
+	waitCount(1)
+	hub.Publish("orders", "update", []byte("a\nb"))
+	r := bufio.NewReader(resp.Body)
+	var event, ping string
+	for event == "" || ping == "" {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case line == ": ping\n":
+			ping = line
+		case strings.HasPrefix(line, "event:"):
+			for line != "\n" {
+				event += line
+				if line, err = r.ReadString('\n'); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+	}
+	if event != "event: update\ndata: a\ndata: b\n" {
+		t.Fatalf("unexpected event %q", event)
+	}
+
+	// the client goes away
+	resp.Body.Close()
+	waitCount(0)
+}
+
+func TestHubModule(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	e := &Engine{Core: New(), Ctx: ctx}
+	m := &HubModule{Hub: NewHub()}
+	done := make(chan error, 1)
+	go func() { done <- m.Start(e) }()
+
+	// the streams are closed when the server shutdown starts
+	s := m.Subscribe("orders")
+	for closed := false; !closed; {
+		e.Server.Shutdown(context.Background())
+		select {
+		case <-s.Done():
+			closed = true
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
+	if m.Subscribe("orders"); m.Count("orders") != 0 {
+		t.Fatalf("subscribed to a closed hub")
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Start did not return with the engine")
+	}
+}
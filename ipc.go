@@ -0,0 +1,398 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/bytedance/sonic"
+)
+
+// envIPCFD the socket a prefork worker talks to the master with
+const envIPCFD = "CORE_IPC_FD"
+
+// IPC message kinds
+const (
+	IPCBroadcast = "broadcast"
+	IPCRequest   = "request"
+	IPCReply     = "reply"
+)
+
+const (
+	ipcMetricsTopic = "core.metrics"
+	ipcMaxFrame     = 16 << 20
+)
+
+var (
+	ErrIPCNoHandler    = errors.New("ipc: no handler for topic")
+	ErrIPCNoWorker     = errors.New("ipc: worker not connected")
+	ErrIPCNotSupported = errors.New("ipc: not supported on this platform")
+	ErrIPCFrameSize    = errors.New("ipc: frame too large")
+)
+
+// IPCMessage exchanged between the prefork master and its workers. From is
+// the worker id, -1 for the master.
+type IPCMessage struct {
+	Kind  string `json:"kind"`
+	ID    uint64 `json:"id,omitempty"`
+	Topic string `json:"topic"`
+	From  int    `json:"from"`
+	Data  []byte `json:"data,omitempty"`
+	Error string `json:"error,omitempty"`
+}
+
+// IPCHandler answer a request
+type IPCHandler func(msg *IPCMessage) ([]byte, error)
+
+// IPCMetrics metrics collected from the workers
+type IPCMetrics struct {
+	Workers map[int]map[string]float64
+	Total   map[string]float64
+}
+
+type ipcConn struct {
+	net.Conn
+	wmu sync.Mutex
+}
+
+func (pc *ipcConn) send(msg *IPCMessage) error {
+	raw, err := sonic.Marshal(msg)
+	if err != nil {
+		return err
+	}
+	buf := make([]byte, 4+len(raw))
+	binary.BigEndian.PutUint32(buf, uint32(len(raw)))
+	copy(buf[4:], raw)
+	pc.wmu.Lock()
+	defer pc.wmu.Unlock()
+	_, err = pc.Write(buf)
+	return err
+}
+
+func readIPC(r *bufio.Reader) (*IPCMessage, error) {
+	var head [4]byte
+	if _, err := io.ReadFull(r, head[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(head[:])
+	if n > ipcMaxFrame {
+		return nil, ErrIPCFrameSize
+	}
+	raw := make([]byte, n)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return nil, err
+	}
+	msg := new(IPCMessage)
+	return msg, sonic.Unmarshal(raw, msg)
+}
+
+// IPC channel between the prefork master and its workers over inherited unix
+// socketpairs. Broadcasts reach every process, workers send requests to the
+// master and the master collects metrics from the workers.
+//
+// Without prefork it works in process: broadcasts and requests are
+// delivered locally, so the same code runs in both modes.
+//
+//	app.IPC().On("cache.invalidate", func(msg *core.IPCMessage) {
+//		cache.Delete(string(msg.Data))
+//	})
+//	app.IPC().Broadcast("cache.invalidate", []byte(key))
+type IPC struct {
+	id        int
+	mu        sync.RWMutex
+	listeners map[string][]func(*IPCMessage)
+	handlers  map[string]IPCHandler
+	metrics   func() map[string]float64
+	conns     map[int]*ipcConn
+	seq       uint64
+	pending   map[uint64]chan *IPCMessage
+}
+
+func newIPC() *IPC {
+	return &IPC{
+		id:        -1,
+		listeners: make(map[string][]func(*IPCMessage)),
+		handlers:  make(map[string]IPCHandler),
+		conns:     make(map[int]*ipcConn),
+		pending:   make(map[uint64]chan *IPCMessage),
+	}
+}
+
+// IPC returns the channel to the prefork master and workers
+func (c *Core) IPC() *IPC {
+	return c.ipc
+}
+
+// ID returns the worker id, -1 in the master
+func (p *IPC) ID() int {
+	return p.id
+}
+
+// On register fn for broadcasts on topic
+func (p *IPC) On(topic string, fn func(msg *IPCMessage)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.listeners[topic] = append(p.listeners[topic], fn)
+}
+
+// Handle register the handler answering requests on topic, requests are
+// sent to the master so handlers are registered there.
+func (p *IPC) Handle(topic string, h IPCHandler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.handlers[topic] = h
+}
+
+// SetMetrics set the function reporting the metrics of this worker
+func (p *IPC) SetMetrics(fn func() map[string]float64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metrics = fn
+}
+
+// Broadcast deliver data on topic to the master and every worker, this
+// process included.
+func (p *IPC) Broadcast(topic string, data []byte) error {
+	msg := &IPCMessage{Kind: IPCBroadcast, Topic: topic, From: p.id, Data: data}
+	if p.id >= 0 {
+		// the master relays it back to every worker
+		if pc := p.conn(-1); pc != nil {
+			return pc.send(msg)
+		}
+	}
+	p.deliver(msg)
+	return p.sendAll(msg)
+}
+
+// Request send a request to the master and wait for the reply
+func (p *IPC) Request(ctx context.Context, topic string, data []byte) ([]byte, error) {
+	if p.id < 0 {
+		return p.handle(&IPCMessage{Kind: IPCRequest, Topic: topic, From: p.id, Data: data})
+	}
+	return p.request(ctx, -1, topic, data)
+}
+
+// RequestWorker send a request to a worker from the master and wait for the reply
+func (p *IPC) RequestWorker(ctx context.Context, id int, topic string, data []byte) ([]byte, error) {
+	return p.request(ctx, id, topic, data)
+}
+
+// Metrics collect the metrics of every worker, Total sums them by name
+func (p *IPC) Metrics(ctx context.Context) (IPCMetrics, error) {
+	res := IPCMetrics{
+		Workers: make(map[int]map[string]float64),
+		Total:   make(map[string]float64),
+	}
+	if len(p.workerIDs()) == 0 {
+		// without prefork this process is the only worker
+		if m := p.localMetrics(); m != nil {
+			res.Workers[p.id] = m
+			for k, v := range m {
+				res.Total[k] += v
+			}
+		}
+		return res, nil
+	}
+	var (
+		mu   sync.Mutex
+		wg   sync.WaitGroup
+		errs []error
+	)
+	for _, id := range p.workerIDs() {
+		wg.Add(1)
+		go func(id int) {
+			defer wg.Done()
+			raw, err := p.request(ctx, id, ipcMetricsTopic, nil)
+			m := make(map[string]float64)
+			if err == nil {
+				err = sonic.Unmarshal(raw, &m)
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("worker %d: %w", id, err))
+				return
+			}
+			res.Workers[id] = m
+			for k, v := range m {
+				res.Total[k] += v
+			}
+		}(id)
+	}
+	wg.Wait()
+	if len(errs) > 0 {
+		return res, fmt.Errorf("ipc: metrics: %v", errs)
+	}
+	return res, nil
+}
+
+func (p *IPC) localMetrics() map[string]float64 {
+	p.mu.RLock()
+	fn := p.metrics
+	p.mu.RUnlock()
+	if fn == nil {
+		return nil
+	}
+	return fn()
+}
+
+func (p *IPC) request(ctx context.Context, to int, topic string, data []byte) ([]byte, error) {
+	pc := p.conn(to)
+	if pc == nil {
+		return nil, ErrIPCNoWorker
+	}
+	id := atomic.AddUint64(&p.seq, 1)
+	reply := make(chan *IPCMessage, 1)
+	p.mu.Lock()
+	p.pending[id] = reply
+	p.mu.Unlock()
+	defer func() {
+		p.mu.Lock()
+		delete(p.pending, id)
+		p.mu.Unlock()
+	}()
+	if err := pc.send(&IPCMessage{Kind: IPCRequest, ID: id, Topic: topic, From: p.id, Data: data}); err != nil {
+		return nil, err
+	}
+	select {
+	case msg := <-reply:
+		if msg.Error != "" {
+			return nil, errors.New(msg.Error)
+		}
+		return msg.Data, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func (p *IPC) handle(msg *IPCMessage) ([]byte, error) {
+	if msg.Topic == ipcMetricsTopic {
+		return sonic.Marshal(p.localMetrics())
+	}
+	p.mu.RLock()
+	h, ok := p.handlers[msg.Topic]
+	p.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("%w %s", ErrIPCNoHandler, msg.Topic)
+	}
+	return h(msg)
+}
+
+func (p *IPC) deliver(msg *IPCMessage) {
+	p.mu.RLock()
+	fns := p.listeners[msg.Topic]
+	p.mu.RUnlock()
+	for _, fn := range fns {
+		fn(msg)
+	}
+}
+
+func (p *IPC) conn(id int) *ipcConn {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	return p.conns[id]
+}
+
+func (p *IPC) workerIDs() []int {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	ids := make([]int, 0, len(p.conns))
+	for id := range p.conns {
+		if id >= 0 {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+func (p *IPC) sendAll(msg *IPCMessage) error {
+	var err error
+	for _, id := range p.workerIDs() {
+		if pc := p.conn(id); pc != nil {
+			if e := pc.send(msg); e != nil {
+				err = e
+			}
+		}
+	}
+	return err
+}
+
+// attach serve conn to the worker id or to the master when id is -1, it
+// replaces a previous conn of the same worker.
+func (p *IPC) attach(id int, conn net.Conn) {
+	pc := &ipcConn{Conn: conn}
+	p.mu.Lock()
+	if old, ok := p.conns[id]; ok {
+		old.Close()
+	}
+	p.conns[id] = pc
+	p.mu.Unlock()
+	go p.serve(id, pc)
+}
+
+func (p *IPC) serve(id int, pc *ipcConn) {
+	defer func() {
+		pc.Close()
+		p.mu.Lock()
+		if p.conns[id] == pc {
+			delete(p.conns, id)
+		}
+		p.mu.Unlock()
+	}()
+	r := bufio.NewReader(pc)
+	for {
+		msg, err := readIPC(r)
+		if err != nil {
+			return
+		}
+		switch msg.Kind {
+		case IPCBroadcast:
+			p.deliver(msg)
+			if p.id < 0 {
+				p.sendAll(msg)
+			}
+		case IPCReply:
+			p.mu.RLock()
+			reply, ok := p.pending[msg.ID]
+			p.mu.RUnlock()
+			if ok {
+				reply <- msg
+			}
+		case IPCRequest:
+			go func(msg *IPCMessage) {
+				data, err := p.handle(msg)
+				reply := &IPCMessage{Kind: IPCReply, ID: msg.ID, Topic: msg.Topic, From: p.id, Data: data}
+				if err != nil {
+					reply.Error = err.Error()
+				}
+				pc.send(reply)
+			}(msg)
+		}
+	}
+}
+
+// connectMaster connect a prefork worker to its master
+func (p *IPC) connectMaster() {
+	fd, err := strconv.Atoi(os.Getenv(envIPCFD))
+	if err != nil {
+		return
+	}
+	os.Unsetenv(envIPCFD)
+	f := os.NewFile(uintptr(fd), "ipc")
+	conn, err := net.FileConn(f)
+	f.Close()
+	if err != nil {
+		Erro("ipc: %s", err)
+		return
+	}
+	p.id = WorkerID()
+	p.attach(-1, conn)
+}
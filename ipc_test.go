@@ -0,0 +1,80 @@
+package core
+
+import (
+	"context"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestIPCLocal(t *testing.T) {
+	p := newIPC()
+	got := make(chan string, 1)
+	p.On("cache.invalidate", func(msg *IPCMessage) { got <- string(msg.Data) })
+	p.Handle("echo", func(msg *IPCMessage) ([]byte, error) { return msg.Data, nil })
+	p.SetMetrics(func() map[string]float64 { return map[string]float64{"requests": 3} })
+
+	p.Broadcast("cache.invalidate", []byte("key"))
+	if v := <-got; v != "key" {
+		t.Fatalf("unexpected broadcast %q", v)
+	}
+	if data, err := p.Request(context.Background(), "echo", []byte("hi")); err != nil || string(data) != "hi" {
+		t.Fatalf("unexpected reply %q %v", data, err)
+	}
+	if m, err := p.Metrics(context.Background()); err != nil || m.Total["requests"] != 3 {
+		t.Fatalf("unexpected metrics %+v %v", m, err)
+	}
+}
+
+func TestIPCMasterWorkers(t *testing.T) {
+	master := newIPC()
+	master.Handle("config", func(msg *IPCMessage) ([]byte, error) {
+		return []byte("v2"), nil
+	})
+	workers := make([]*IPC, 2)
+	var wg sync.WaitGroup
+	for i := range workers {
+		w := newIPC()
+		w.id = i
+		w.SetMetrics(func() map[string]float64 { return map[string]float64{"requests": 2} })
+		wg.Add(1)
+		w.On("reload", func(msg *IPCMessage) { wg.Done() })
+		a, b := net.Pipe()
+		master.attach(i, a)
+		w.attach(-1, b)
+		workers[i] = w
+	}
+	wg.Add(1)
+	master.On("reload", func(msg *IPCMessage) {
+		if msg.From != 1 {
+			t.Errorf("unexpected sender %d", msg.From)
+		}
+		wg.Done()
+	})
+
+	// a worker broadcast reaches the master and every worker
+	if err := workers[1].Broadcast("reload", nil); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan struct{})
+	go func() { wg.Wait(); close(done) }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("broadcast not delivered")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if data, err := workers[0].Request(ctx, "config", nil); err != nil || string(data) != "v2" {
+		t.Fatalf("unexpected reply %q %v", data, err)
+	}
+	if _, err := workers[0].Request(ctx, "missing", nil); err == nil {
+		t.Fatalf("expected error for unknown topic")
+	}
+	m, err := master.Metrics(ctx)
+	if err != nil || len(m.Workers) != 2 || m.Total["requests"] != 4 {
+		t.Fatalf("unexpected metrics %+v %v", m, err)
+	}
+}
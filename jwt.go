@@ -0,0 +1,556 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+const jwtClaimsKey = "claims"
+
+var (
+	ErrJWTMissing    = NewError(StatusUnauthorized, "jwt: missing token")
+	ErrJWTInvalid    = NewError(StatusUnauthorized, "jwt: invalid token")
+	ErrJWTExpired    = NewError(StatusUnauthorized, "jwt: token expired")
+	ErrJWTNotYet     = NewError(StatusUnauthorized, "jwt: token not valid yet")
+	ErrJWTAudience   = NewError(StatusUnauthorized, "jwt: invalid audience")
+	ErrJWTIssuer     = NewError(StatusUnauthorized, "jwt: invalid issuer")
+	ErrJWTUnknownKey = NewError(StatusUnauthorized, "jwt: unknown key")
+	ErrNoClaims      = errors.New("jwt: no claims")
+)
+
+// JWT signing algorithms
+const (
+	JWTHS256 = "HS256"
+	JWTRS256 = "RS256"
+	JWTES256 = "ES256"
+	JWTEdDSA = "EdDSA"
+)
+
+// JWTConfig options of the JWT middleware
+//
+//	jwt:
+//	  secret: "hmac secret"            # HS256
+//	  public_key: certs/jwt.pem        # PEM of a RS256, ES256 or EdDSA key
+//	  jwks: https://auth.example.com/.well-known/jwks.json  # or a file
+//	  jwks_ttl: 1h                     # default 1h
+//	  algs: [RS256]                    # accepted, default all of them
+//	  issuer: https://auth.example.com
+//	  audience: [api]
+//	  leeway: 30s                      # clock skew of exp and nbf
+//	  lookup:                          # where the token is, default the bearer of Authorization
+//	    - header:Authorization
+//	    - cookie:jwt
+//	    - query:token
+//	  optional: false                  # requests without a token pass
+type JWTConfig struct {
+	Secret    []byte
+	PublicKey crypto.PublicKey // *rsa.PublicKey, *ecdsa.PublicKey or ed25519.PublicKey
+	JWKS      string           // url or file of a JSON Web Key Set
+	JWKSTTL   time.Duration
+	Algs      []string
+	Issuer    string
+	Audience  []string
+	Leeway    time.Duration
+	Lookup    []string
+	Optional  bool
+}
+
+// NewJWTConfig returns the JWTConfig of the jwt section
+func NewJWTConfig(conf Options) (JWTConfig, error) {
+	cfg := JWTConfig{
+		JWKS:     conf.GetString("jwks"),
+		JWKSTTL:  conf.GetDuration("jwks_ttl", time.Hour),
+		Algs:     conf.GetStrings("algs"),
+		Issuer:   conf.GetString("issuer"),
+		Audience: conf.GetStrings("audience"),
+		Leeway:   conf.GetDuration("leeway"),
+		Lookup:   conf.GetStrings("lookup"),
+		Optional: conf.GetBool("optional"),
+	}
+	if secret := conf.GetString("secret"); secret != "" {
+		cfg.Secret = []byte(secret)
+	}
+	if file := conf.GetString("public_key"); file != "" {
+		raw, err := os.ReadFile(file)
+		if err != nil {
+			return cfg, err
+		}
+		if cfg.PublicKey, err = ParsePublicKeyPEM(raw); err != nil {
+			return cfg, err
+		}
+	}
+	if cfg.Secret == nil && cfg.PublicKey == nil && cfg.JWKS == "" {
+		return cfg, errors.New("jwt: one of secret, public_key or jwks is required")
+	}
+	return cfg, nil
+}
+
+// ParsePublicKeyPEM parse a PKIX public key or a certificate
+func ParsePublicKeyPEM(raw []byte) (crypto.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("jwt: no PEM block")
+	}
+	if block.Type == "CERTIFICATE" {
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		return cert.PublicKey, nil
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
+
+// JWT middleware authenticate the request by a JSON Web Token, the claims
+// are kept in the vars of the Ctx and read by c.Claims, the sub and the
+// roles claims are the principal. Without conf it is configured by the jwt section.
+//
+//	app.Use("/api", core.JWT())
+//
+//	var claims struct{ Sub string `json:"sub"` }
+//	c.Claims(&claims)
+func JWT(conf ...JWTConfig) HandlerFunc {
+	var (
+		once sync.Once
+		v    *jwtVerifier
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			var cfg JWTConfig
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				var err error
+				if cfg, err = NewJWTConfig(c.Core().Conf.GetMap("jwt")); err != nil {
+					panic(err)
+				}
+			}
+			v = newJWTVerifier(cfg)
+		})
+		token := v.lookup(c)
+		if token == "" {
+			if v.Optional {
+				return
+			}
+			v.deny(c, ErrJWTMissing)
+			return
+		}
+		claims, err := v.verify(token, time.Now())
+		if err != nil {
+			v.deny(c, err)
+			return
+		}
+		c.Set(jwtClaimsKey, claims)
+		c.SetPrincipal(&Principal{ID: claims.GetString("sub"), Scheme: "bearer", Roles: claimStrings(claims["roles"])})
+	}
+}
+
+// Claims decode the claims of the JWT middleware into v, a *Map keeps them
+// as they are.
+func (c *Ctx) Claims(v interface{}) error {
+	claims, ok := c.Get(jwtClaimsKey)
+	if !ok {
+		return ErrNoClaims
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() == reflect.Ptr && !rv.IsNil() && reflect.TypeOf(claims).ConvertibleTo(rv.Elem().Type()) {
+		return c.GetAs(jwtClaimsKey, v)
+	}
+	raw, err := sonic.Marshal(claims)
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(raw, v)
+}
+
+type jwtVerifier struct {
+	JWTConfig
+	jwks *jwks
+}
+
+func newJWTVerifier(cfg JWTConfig) *jwtVerifier {
+	v := &jwtVerifier{JWTConfig: cfg}
+	if len(v.Lookup) == 0 {
+		v.Lookup = []string{"header:" + HeaderAuthorization}
+	}
+	if cfg.JWKS != "" {
+		v.jwks = &jwks{source: cfg.JWKS, ttl: cfg.JWKSTTL}
+		if v.jwks.ttl <= 0 {
+			v.jwks.ttl = time.Hour
+		}
+	}
+	return v
+}
+
+func (v *jwtVerifier) deny(c *Ctx, err error) {
+	e, ok := err.(*Error)
+	if !ok {
+		e = ErrJWTInvalid
+	}
+	c.SetHeader(HeaderWWWAuthenticate, `Bearer error="invalid_token"`)
+	c.Abort().SendStatus(e.Code, e.Message)
+}
+
+// lookup returns the first token of the lookup sources
+func (v *jwtVerifier) lookup(c *Ctx) string {
+	for _, src := range v.Lookup {
+		kind, name, _ := strings.Cut(src, ":")
+		var token string
+		switch kind {
+		case "header":
+			token = c.GetHeader(name)
+			if strings.EqualFold(name, HeaderAuthorization) {
+				if len(token) > 7 && strings.EqualFold(token[:7], "Bearer ") {
+					token = token[7:]
+				} else {
+					token = ""
+				}
+			}
+		case "cookie":
+			if ck, err := c.R.Cookie(name); err == nil {
+				token = ck.Value
+			}
+		case "query":
+			token = c.R.URL.Query().Get(name)
+		}
+		if token = strings.TrimSpace(token); token != "" {
+			return token
+		}
+	}
+	return ""
+}
+
+// verify check the signature and the registered claims of a token
+func (v *jwtVerifier) verify(token string, now time.Time) (Map, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrJWTInvalid
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := jwtDecode(parts[0], &header); err != nil {
+		return nil, ErrJWTInvalid
+	}
+	if !v.allowAlg(header.Alg) {
+		return nil, ErrJWTInvalid
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, ErrJWTInvalid
+	}
+	key, err := v.key(header.Alg, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if !jwtVerify(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, ErrJWTInvalid
+	}
+	claims := Map{}
+	if err := jwtDecode(parts[1], &claims); err != nil {
+		return nil, ErrJWTInvalid
+	}
+	return claims, v.validate(claims, now)
+}
+
+func (v *jwtVerifier) allowAlg(alg string) bool {
+	switch alg {
+	case JWTHS256, JWTRS256, JWTES256, JWTEdDSA:
+	default: // none and the others
+		return false
+	}
+	if len(v.Algs) == 0 {
+		return true
+	}
+	for _, a := range v.Algs {
+		if a == alg {
+			return true
+		}
+	}
+	return false
+}
+
+// key returns the key of the kid from the JWKS, else the configured one
+func (v *jwtVerifier) key(alg, kid string) (interface{}, error) {
+	if v.jwks != nil {
+		key, err := v.jwks.key(kid)
+		if err == nil || (v.Secret == nil && v.PublicKey == nil) {
+			return key, err
+		}
+	}
+	if alg == JWTHS256 {
+		if v.Secret == nil {
+			return nil, ErrJWTUnknownKey
+		}
+		return v.Secret, nil
+	}
+	if v.PublicKey == nil {
+		return nil, ErrJWTUnknownKey
+	}
+	return v.PublicKey, nil
+}
+
+func (v *jwtVerifier) validate(claims Map, now time.Time) error {
+	exp, err := numericDate(claims["exp"])
+	if err != nil {
+		return err
+	}
+	if !exp.IsZero() && now.After(exp.Add(v.Leeway)) {
+		return ErrJWTExpired
+	}
+	nbf, err := numericDate(claims["nbf"])
+	if err != nil {
+		return err
+	}
+	if !nbf.IsZero() && now.Add(v.Leeway).Before(nbf) {
+		return ErrJWTNotYet
+	}
+	if v.Issuer != "" && claims.GetString("iss") != v.Issuer {
+		return ErrJWTIssuer
+	}
+	if len(v.Audience) > 0 {
+		aud := claimStrings(claims["aud"])
+		for _, want := range v.Audience {
+			for _, got := range aud {
+				if got == want {
+					return nil
+				}
+			}
+		}
+		return ErrJWTAudience
+	}
+	return nil
+}
+
+// numericDate returns the time of an exp or nbf claim, zero without it and
+// ErrJWTInvalid when it is not a number
+func numericDate(claim interface{}) (time.Time, error) {
+	switch v := claim.(type) {
+	case nil:
+		return time.Time{}, nil
+	case float64:
+		return time.Unix(int64(v), 0), nil
+	}
+	return time.Time{}, ErrJWTInvalid
+}
+
+// claimStrings returns a claim which is a string or a list of them
+func claimStrings(claim interface{}) []string {
+	switch v := claim.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		strs := make([]string, 0, len(v))
+		for _, s := range v {
+			strs = append(strs, fmt.Sprint(s))
+		}
+		return strs
+	}
+	return nil
+}
+
+func jwtDecode(seg string, v interface{}) error {
+	raw, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(raw, v)
+}
+
+// jwtVerify check the signature of the signing input by the key of alg
+func jwtVerify(alg string, key interface{}, input, sig []byte) bool {
+	sum := sha256.Sum256(input)
+	switch alg {
+	case JWTHS256:
+		secret, ok := key.([]byte)
+		if !ok {
+			return false
+		}
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(input)
+		return hmac.Equal(sig, mac.Sum(nil))
+	case JWTRS256:
+		pub, ok := key.(*rsa.PublicKey)
+		return ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], sig) == nil
+	case JWTES256:
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok || pub.Curve != elliptic.P256() || len(sig) != 64 {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:32]), new(big.Int).SetBytes(sig[32:])
+		return ecdsa.Verify(pub, sum[:], r, s)
+	case JWTEdDSA:
+		pub, ok := key.(ed25519.PublicKey)
+		return ok && ed25519.Verify(pub, input, sig)
+	}
+	return false
+}
+
+// jwksMinRefresh limit the reloads of a JWKS for unknown kids
+const jwksMinRefresh = time.Minute
+
+// jwks a cached JSON Web Key Set of a url or a file
+type jwks struct {
+	source  string
+	ttl     time.Duration
+	mu      sync.Mutex
+	keys    map[string]interface{}
+	fetched time.Time
+	tried   time.Time     // start of the last load
+	loading chan struct{} // closed once the load in flight is done
+}
+
+// key returns the key of kid. An expired set is reloaded in the background
+// while its keys are still served, an unknown kid waits for a reload, at
+// most one per jwksMinRefresh, rotated keys being published before they are
+// used.
+func (s *jwks) key(kid string) (interface{}, error) {
+	s.mu.Lock()
+	key, ok := s.keys[kid]
+	if ok {
+		if time.Since(s.fetched) >= s.ttl {
+			s.reload()
+		}
+		s.mu.Unlock()
+		return key, nil
+	}
+	if s.loading == nil && time.Since(s.tried) < jwksMinRefresh {
+		s.mu.Unlock()
+		return nil, ErrJWTUnknownKey
+	}
+	done := s.reload()
+	s.mu.Unlock()
+	<-done
+	s.mu.Lock()
+	key, ok = s.keys[kid]
+	s.mu.Unlock()
+	if !ok {
+		return nil, ErrJWTUnknownKey
+	}
+	return key, nil
+}
+
+// reload start loading the set unless a load is in flight and returns the
+// channel closed once it is done, s.mu is held
+func (s *jwks) reload() chan struct{} {
+	if s.loading != nil {
+		return s.loading
+	}
+	done := make(chan struct{})
+	s.loading, s.tried = done, time.Now()
+	go func() {
+		keys, err := s.load()
+		s.mu.Lock()
+		if err != nil {
+			Warn("jwt: jwks %s: %s", s.source, err)
+		} else {
+			s.keys, s.fetched = keys, time.Now()
+		}
+		s.loading = nil
+		s.mu.Unlock()
+		close(done)
+	}()
+	return done
+}
+
+var jwksClient = &http.Client{Timeout: 10 * time.Second}
+
+func (s *jwks) load() (map[string]interface{}, error) {
+	var raw []byte
+	if strings.HasPrefix(s.source, "http://") || strings.HasPrefix(s.source, "https://") {
+		resp, err := jwksClient.Get(s.source)
+		if err != nil {
+			return nil, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != StatusOK {
+			return nil, fmt.Errorf("status %d", resp.StatusCode)
+		}
+		if raw, err = io.ReadAll(io.LimitReader(resp.Body, 1<<20)); err != nil {
+			return nil, err
+		}
+	} else {
+		var err error
+		if raw, err = os.ReadFile(s.source); err != nil {
+			return nil, err
+		}
+	}
+	return ParseJWKS(raw)
+}
+
+// ParseJWKS returns the keys of a JSON Web Key Set by kid
+func ParseJWKS(raw []byte) (map[string]interface{}, error) {
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := sonic.Unmarshal(raw, &set); err != nil {
+		return nil, err
+	}
+	b64 := base64.RawURLEncoding.DecodeString
+	keys := make(map[string]interface{}, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch {
+		case k.Kty == "RSA":
+			n, err1 := b64(k.N)
+			e, err2 := b64(k.E)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("jwks: invalid RSA key %q", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case k.Kty == "EC" && k.Crv == "P-256":
+			x, err1 := b64(k.X)
+			y, err2 := b64(k.Y)
+			if err1 != nil || err2 != nil {
+				return nil, fmt.Errorf("jwks: invalid EC key %q", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		case k.Kty == "OKP" && k.Crv == "Ed25519":
+			x, err := b64(k.X)
+			if err != nil || len(x) != ed25519.PublicKeySize {
+				return nil, fmt.Errorf("jwks: invalid Ed25519 key %q", k.Kid)
+			}
+			keys[k.Kid] = ed25519.PublicKey(x)
+		case k.Kty == "oct":
+			secret, err := b64(k.K)
+			if err != nil {
+				return nil, fmt.Errorf("jwks: invalid oct key %q", k.Kid)
+			}
+			keys[k.Kid] = secret
+		}
+	}
+	return keys, nil
+}
@@ -0,0 +1,194 @@
+package core
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+func signJWT(t *testing.T, alg, kid string, key interface{}, claims Map) string {
+	b64 := base64.RawURLEncoding.EncodeToString
+	header, _ := sonic.Marshal(Map{"alg": alg, "typ": "JWT", "kid": kid})
+	payload, _ := sonic.Marshal(claims)
+	input := b64(header) + "." + b64(payload)
+	sum := sha256.Sum256([]byte(input))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		mac := hmac.New(sha256.New, k)
+		mac.Write([]byte(input))
+		sig = mac.Sum(nil)
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:])
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		r.FillBytes(sig[:32])
+		s.FillBytes(sig[32:])
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(k, []byte(input))
+	}
+	return input + "." + b64(sig)
+}
+
+func TestJWT(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	edPub, edKey, _ := ed25519.GenerateKey(rand.Reader)
+	b64 := base64.RawURLEncoding.EncodeToString
+	jwksBody, _ := sonic.Marshal(Map{"keys": []Map{
+		{"kty": "RSA", "kid": "rsa", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": b64(edPub)},
+	}})
+	fetches := 0
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches++
+		w.Write(jwksBody)
+	}))
+	defer jwksServer.Close()
+
+	secret := []byte("secret")
+	app := New()
+	app.Use(JWT(JWTConfig{
+		Secret:   secret,
+		JWKS:     jwksServer.URL,
+		Audience: []string{"api"},
+		Leeway:   time.Minute,
+		Lookup:   []string{"header:Authorization", "cookie:jwt", "query:token"},
+	}))
+	app.Get("/me", func(c *Ctx) {
+		var claims struct {
+			Sub  string `json:"sub"`
+			Role string `json:"role"`
+		}
+		var m Map
+		if err := c.Claims(&claims); err != nil || c.Claims(&m) != nil {
+			c.SendString("no claims")
+			return
+		}
+		c.SendString(claims.Sub + "|" + claims.Role + "|" + m.GetString("sub"))
+	})
+	serve := func(token, via string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", "/me", nil)
+		switch via {
+		case "cookie":
+			req.AddCookie(&http.Cookie{Name: "jwt", Value: token})
+		case "query":
+			req.URL.RawQuery = "token=" + token
+		default:
+			req.Header.Set(HeaderAuthorization, "Bearer "+token)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	now := time.Now().Unix()
+	claims := Map{"sub": "ann", "role": "admin", "aud": []string{"web", "api"}, "exp": now + 60}
+	for _, tc := range []struct {
+		alg, kid, via string
+		key           interface{}
+	}{
+		{JWTHS256, "", "header", secret},
+		{JWTRS256, "rsa", "cookie", rsaKey},
+		{JWTES256, "ec", "query", ecKey},
+		{JWTEdDSA, "ed", "header", edKey},
+	} {
+		if w := serve(signJWT(t, tc.alg, tc.kid, tc.key, claims), tc.via); w.Body.String() != "ann|admin|ann" {
+			t.Fatalf("%s: got %d %q", tc.alg, w.Code, w.Body.String())
+		}
+	}
+	if fetches != 1 {
+		t.Fatalf("jwks fetched %d times", fetches)
+	}
+
+	for name, c := range map[string]Map{
+		"expired":      {"sub": "ann", "aud": "api", "exp": now - 120},
+		"not yet":      {"sub": "ann", "aud": "api", "nbf": now + 120},
+		"bad audience": {"sub": "ann", "aud": "web"},
+		"string exp":   {"sub": "ann", "aud": "api", "exp": "never"},
+		"string nbf":   {"sub": "ann", "aud": "api", "nbf": "later"},
+	} {
+		if w := serve(signJWT(t, JWTHS256, "", secret, c), "header"); w.Code != StatusUnauthorized {
+			t.Fatalf("%s: got %d", name, w.Code)
+		}
+	}
+	// within the leeway
+	if w := serve(signJWT(t, JWTHS256, "", secret, Map{"sub": "ann", "aud": "api", "exp": now - 30}), "header"); w.Code != StatusOK {
+		t.Fatalf("leeway: got %d", w.Code)
+	}
+
+	token := signJWT(t, JWTHS256, "", secret, claims)
+	if w := serve(token[:len(token)-2]+"xx", "header"); w.Code != StatusUnauthorized ||
+		!strings.HasPrefix(w.Header().Get(HeaderWWWAuthenticate), "Bearer") {
+		t.Fatalf("tampered token got %d", w.Code)
+	}
+	// a public key used as a HMAC secret
+	pemLike := []byte(b64(rsaKey.N.Bytes()))
+	if w := serve(signJWT(t, JWTHS256, "rsa", pemLike, claims), "header"); w.Code != StatusUnauthorized {
+		t.Fatalf("alg confusion got %d", w.Code)
+	}
+	if w := serve("", "header"); w.Code != StatusUnauthorized {
+		t.Fatalf("missing token got %d", w.Code)
+	}
+}
+
+func TestJWKSRefresh(t *testing.T) {
+	edPub, _, _ := ed25519.GenerateKey(rand.Reader)
+	body, _ := sonic.Marshal(Map{"keys": []Map{
+		{"kty": "OKP", "kid": "ed", "crv": "Ed25519", "x": base64.RawURLEncoding.EncodeToString(edPub)},
+	}})
+	var fetches int64
+	gate := make(chan struct{}, 1)
+	gate <- struct{}{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-gate
+		atomic.AddInt64(&fetches, 1)
+		w.Write(body)
+	}))
+	defer server.Close()
+	set := &jwks{source: server.URL, ttl: 20 * time.Millisecond}
+	if _, err := set.key("ed"); err != nil {
+		t.Fatal(err)
+	}
+
+	// expired, the cached key is served while the endpoint hangs
+	time.Sleep(30 * time.Millisecond)
+	start := time.Now()
+	for i := 0; i < 10; i++ {
+		if _, err := set.key("ed"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if time.Since(start) > 100*time.Millisecond {
+		t.Fatalf("cached key blocked %s behind the reload", time.Since(start))
+	}
+	gate <- struct{}{}
+	for i := 0; i < 100 && atomic.LoadInt64(&fetches) < 2; i++ {
+		time.Sleep(5 * time.Millisecond)
+	}
+	// unknown kids do not reload again within jwksMinRefresh
+	time.Sleep(10 * time.Millisecond)
+	if _, err := set.key("other"); err != ErrJWTUnknownKey || atomic.LoadInt64(&fetches) != 2 {
+		t.Fatalf("unknown kid got %v after %d fetches", err, fetches)
+	}
+}
@@ -0,0 +1,123 @@
+package core
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"strings"
+)
+
+var (
+	ErrNoKeys       = errors.New("keys: no key configured")
+	ErrInvalidValue = errors.New("keys: invalid or tampered value")
+)
+
+// Keyring signs and encrypts with its first key while every key verifies
+// and decrypts, so a new key is put first and old ones are dropped once the
+// values they protect expired.
+//
+//	keys:
+//	  - "new secret"
+//	  - "old secret"
+type Keyring struct {
+	keys []ringKey
+}
+
+type ringKey struct {
+	mac  []byte
+	aead cipher.AEAD
+}
+
+// NewKeyring returns a Keyring of secrets, newest first. The signing and
+// encryption keys are derived from each secret.
+func NewKeyring(secrets ...string) (*Keyring, error) {
+	if len(secrets) == 0 {
+		return nil, ErrNoKeys
+	}
+	k := &Keyring{keys: make([]ringKey, 0, len(secrets))}
+	for _, secret := range secrets {
+		mac := sha256.Sum256([]byte("core-mac:" + secret))
+		enc := sha256.Sum256([]byte("core-enc:" + secret))
+		block, err := aes.NewCipher(enc[:])
+		if err != nil {
+			return nil, err
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return nil, err
+		}
+		k.keys = append(k.keys, ringKey{mac: mac[:], aead: aead})
+	}
+	return k, nil
+}
+
+func (key ringKey) sign(name, value string) []byte {
+	h := hmac.New(sha256.New, key.mac)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(value))
+	return h.Sum(nil)
+}
+
+// Sign returns value with the signature of the first key, name binds it to
+// its use e.g the cookie name.
+func (k *Keyring) Sign(name, value string) string {
+	enc := base64.RawURLEncoding
+	return enc.EncodeToString([]byte(value)) + "." + enc.EncodeToString(k.keys[0].sign(name, value))
+}
+
+// Verify returns the value of a signed one when a key verifies it
+func (k *Keyring) Verify(name, signed string) (string, error) {
+	enc := base64.RawURLEncoding
+	v, sig, ok := strings.Cut(signed, ".")
+	if !ok {
+		return "", ErrInvalidValue
+	}
+	value, err := enc.DecodeString(v)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	mac, err := enc.DecodeString(sig)
+	if err != nil {
+		return "", ErrInvalidValue
+	}
+	for _, key := range k.keys {
+		if hmac.Equal(mac, key.sign(name, string(value))) {
+			return string(value), nil
+		}
+	}
+	return "", ErrInvalidValue
+}
+
+// Encrypt seal plain with AES-GCM and the first key, name is authenticated
+// with it.
+func (k *Keyring) Encrypt(name string, plain []byte) (string, error) {
+	aead := k.keys[0].aead
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(aead.Seal(nonce, nonce, plain, []byte(name))), nil
+}
+
+// Decrypt open a value of Encrypt with any key
+func (k *Keyring) Decrypt(name, sealed string) ([]byte, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, ErrInvalidValue
+	}
+	for _, key := range k.keys {
+		n := key.aead.NonceSize()
+		if len(raw) < n {
+			break
+		}
+		if plain, err := key.aead.Open(nil, raw[:n], raw[n:], []byte(name)); err == nil {
+			return plain, nil
+		}
+	}
+	return nil, ErrInvalidValue
+}
@@ -0,0 +1,299 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// minBodyRateGrace time a body is read before min_body_rate applies
+const minBodyRateGrace = 5 * time.Second
+
+// Limits connection and request limits, 0 is unlimited
+//
+//	server:
+//	  max_conns: 10000           # open connections, further ones wait in the backlog
+//	  max_conns_per_ip: 64       # open connections of a tcp peer, behind a proxy the proxy itself
+//	  max_requests_per_ip: 32    # concurrent requests of a RemoteIP, for clients behind proxies
+//	  max_header_bytes: 1048576  # default 1MB
+//	  max_body_bytes: 10485760   # request body, see BodyLimit for routes
+//	  body_read_timeout: 10s     # max wait for the next part of the body
+//	  min_body_rate: 1024        # bytes per second a body is sent at, checked after 5s
+type Limits struct {
+	MaxConns         int
+	MaxConnsPerIP    int
+	MaxRequestsPerIP int
+	MaxHeaderBytes   int
+	MaxBodyBytes     int64
+	BodyReadTimeout  time.Duration
+	MinBodyRate      int64
+}
+
+// NewLimits returns the Limits of the server section
+func NewLimits(conf Options) Limits {
+	return Limits{
+		MaxConns:         conf.GetInt("max_conns"),
+		MaxConnsPerIP:    conf.GetInt("max_conns_per_ip"),
+		MaxRequestsPerIP: conf.GetInt("max_requests_per_ip"),
+		MaxHeaderBytes:   conf.GetInt("max_header_bytes", http.DefaultMaxHeaderBytes),
+		MaxBodyBytes:     conf.GetInt64("max_body_bytes"),
+		BodyReadTimeout:  conf.GetDuration("body_read_timeout"),
+		MinBodyRate:      conf.GetInt64("min_body_rate"),
+	}
+}
+
+// counter count the users of a key, shared by the connection and request limits
+type counter struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+func (c *counter) acquire(key string, max int) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil {
+		c.counts = make(map[string]int)
+	}
+	if c.counts[key] >= max {
+		return false
+	}
+	c.counts[key]++
+	return true
+}
+
+func (c *counter) release(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts[key] <= 1 {
+		delete(c.counts, key)
+		return
+	}
+	c.counts[key]--
+}
+
+// connLimiter limits the connections of every listener of the server
+type connLimiter struct {
+	sem   chan struct{}
+	perIP int
+	ips   counter
+}
+
+// limitListener wait for a free slot before accepting and close the
+// connections of addresses over their limit
+type limitListener struct {
+	net.Listener
+	limiter *connLimiter
+	once    sync.Once
+	done    chan struct{}
+}
+
+func (c *Core) limitListener(ln net.Listener) net.Listener {
+	if c.Limits.MaxConns <= 0 && c.Limits.MaxConnsPerIP <= 0 {
+		return ln
+	}
+	c.life.mu.Lock()
+	if c.conns == nil {
+		c.conns = &connLimiter{perIP: c.Limits.MaxConnsPerIP}
+		if c.Limits.MaxConns > 0 {
+			c.conns.sem = make(chan struct{}, c.Limits.MaxConns)
+		}
+	}
+	limiter := c.conns
+	c.life.mu.Unlock()
+	return &limitListener{Listener: ln, limiter: limiter, done: make(chan struct{})}
+}
+
+func (ln *limitListener) Accept() (net.Conn, error) {
+	for {
+		if ln.limiter.sem != nil {
+			select {
+			case ln.limiter.sem <- struct{}{}:
+			case <-ln.done:
+				return nil, net.ErrClosed
+			}
+		}
+		conn, err := ln.Listener.Accept()
+		if err != nil {
+			ln.release()
+			return nil, err
+		}
+		ip := connIP(conn)
+		if ln.limiter.perIP > 0 && ip != "" && !ln.limiter.ips.acquire(ip, ln.limiter.perIP) {
+			conn.Close()
+			ln.release()
+			continue
+		}
+		if ln.limiter.perIP <= 0 {
+			ip = ""
+		}
+		return &limitConn{Conn: conn, ip: ip, ln: ln}, nil
+	}
+}
+
+func (ln *limitListener) Close() error {
+	ln.once.Do(func() { close(ln.done) })
+	return ln.Listener.Close()
+}
+
+func (ln *limitListener) release() {
+	if ln.limiter.sem != nil {
+		<-ln.limiter.sem
+	}
+}
+
+// connIP returns the ip of a tcp peer, empty for other networks
+func connIP(conn net.Conn) string {
+	if addr, ok := conn.RemoteAddr().(*net.TCPAddr); ok {
+		return addr.IP.String()
+	}
+	return ""
+}
+
+type limitConn struct {
+	net.Conn
+	ip   string
+	ln   *limitListener
+	once sync.Once
+}
+
+func (lc *limitConn) Close() error {
+	err := lc.Conn.Close()
+	lc.once.Do(func() {
+		if lc.ip != "" {
+			lc.ln.limiter.ips.release(lc.ip)
+		}
+		lc.ln.release()
+	})
+	return err
+}
+
+type connCtxKey struct{}
+
+// connContext keep the connection in the request context for the body guards
+func connContext(ctx context.Context, conn net.Conn) context.Context {
+	return context.WithValue(ctx, connCtxKey{}, conn)
+}
+
+// limitedBody enforce the body size limit and the slow body guards
+type limitedBody struct {
+	io.ReadCloser
+	limit    int64
+	length   int64
+	read     int64
+	exceeded bool
+	conn     net.Conn
+	timeout  time.Duration
+	minRate  int64
+	start    time.Time
+	timer    *time.Timer // closes the conn when a Read waits over timeout
+	timedOut int32
+}
+
+// limitBody wrap the request body when a body limit or guard is configured
+func (c *Core) limitBody(ctx *Ctx) {
+	l := c.Limits
+	if ctx.R.Body == nil || ctx.R.Body == http.NoBody ||
+		(l.MaxBodyBytes <= 0 && l.BodyReadTimeout <= 0 && l.MinBodyRate <= 0) {
+		return
+	}
+	conn, _ := ctx.R.Context().Value(connCtxKey{}).(net.Conn)
+	ctx.R.Body = &limitedBody{
+		ReadCloser: ctx.R.Body,
+		limit:      l.MaxBodyBytes,
+		length:     ctx.R.ContentLength,
+		conn:       conn,
+		timeout:    l.BodyReadTimeout,
+		minRate:    l.MinBodyRate,
+	}
+}
+
+func (b *limitedBody) Read(p []byte) (int, error) {
+	if b.exceeded {
+		return 0, ErrRequestEntityTooLarge
+	}
+	if b.limit > 0 {
+		if b.length > b.limit {
+			b.exceeded = true
+			return 0, ErrRequestEntityTooLarge
+		}
+		// read one byte over the limit to notice a larger body
+		if rest := b.limit - b.read + 1; int64(len(p)) > rest {
+			p = p[:rest]
+		}
+	}
+	if b.start.IsZero() {
+		b.start = time.Now()
+	}
+	if b.timeout > 0 && b.conn != nil {
+		if b.timer == nil {
+			b.timer = time.AfterFunc(b.timeout, func() {
+				atomic.StoreInt32(&b.timedOut, 1)
+				b.conn.Close()
+			})
+		} else {
+			b.timer.Reset(b.timeout)
+		}
+		defer b.timer.Stop()
+	}
+	n, err := b.ReadCloser.Read(p)
+	b.read += int64(n)
+	if atomic.LoadInt32(&b.timedOut) == 1 {
+		return n, ErrRequestTimeout
+	}
+	if b.limit > 0 && b.read > b.limit {
+		b.exceeded = true
+		return n - int(b.read-b.limit), ErrRequestEntityTooLarge
+	}
+	if b.minRate > 0 && err == nil {
+		if elapsed := time.Since(b.start); elapsed > minBodyRateGrace &&
+			float64(b.read)/elapsed.Seconds() < float64(b.minRate) {
+			if b.conn != nil {
+				b.conn.Close()
+			}
+			return n, ErrRequestTimeout
+		}
+	}
+	return n, err
+}
+
+// BodyLimit middleware set the maximum request body size of a route, it
+// replaces max_body_bytes. Larger bodies get 413.
+//
+//	app.Post("/upload", core.BodyLimit(64<<20), upload)
+func BodyLimit(n int64) HandlerFunc {
+	return func(c *Ctx) {
+		limitRawBody(c, n)
+	}
+}
+
+// limitRawBody set the limit of the body as sent, false when it is known to
+// be over it and the request got 413
+func limitRawBody(c *Ctx, n int64) bool {
+	if c.R.ContentLength > n {
+		c.Abort().SendStatus(StatusRequestEntityTooLarge, StatusMessage(StatusRequestEntityTooLarge))
+		return false
+	}
+	if lb, ok := c.R.Body.(*limitedBody); ok {
+		lb.limit = n
+	} else if c.R.Body != nil && c.R.Body != http.NoBody {
+		c.R.Body = &limitedBody{ReadCloser: c.R.Body, limit: n, length: c.R.ContentLength}
+	}
+	return true
+}
+
+// bodyTooLarge reports whether a handler hit the body limit, of the body
+// as sent or decoded by Decompress
+func bodyTooLarge(ctx *Ctx) bool {
+	lb, ok := ctx.R.Body.(*limitedBody)
+	if !ok {
+		return false
+	}
+	if d, ok := lb.ReadCloser.(*decodedBody); ok && d.rawTooLarge() {
+		return true
+	}
+	return lb.exceeded
+}
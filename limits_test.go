@@ -0,0 +1,151 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBodyLimit(t *testing.T) {
+	app := New(Options{
+		"listen": "127.0.0.1:0",
+		"server": map[string]interface{}{"max_body_bytes": 8},
+	})
+	read := func(c *Ctx) {
+		body, err := io.ReadAll(c.R.Body)
+		if err != nil {
+			return
+		}
+		c.Send(body)
+	}
+	app.Post("/", read)
+	app.Post("/upload", BodyLimit(16), read)
+	if err := app.GoListenAndServeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown()
+	addr := "http://" + app.listeners[0].ln.Addr().String()
+
+	for _, tc := range []struct {
+		path, body string
+		chunked    bool
+		status     int
+	}{
+		{"/", "12345678", false, StatusOK},
+		{"/", "123456789", false, StatusRequestEntityTooLarge},
+		{"/", "123456789", true, StatusRequestEntityTooLarge},
+		{"/upload", "123456789", false, StatusOK},
+		{"/upload", strings.Repeat("x", 17), false, StatusRequestEntityTooLarge},
+	} {
+		var body io.Reader = strings.NewReader(tc.body)
+		if tc.chunked {
+			body = io.MultiReader(body) // unknown length is sent chunked
+		}
+		resp, err := http.Post(addr+tc.path, "text/plain", body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%s %d bytes chunked %v: status %d, want %d", tc.path, len(tc.body), tc.chunked, resp.StatusCode, tc.status)
+		}
+	}
+}
+
+func TestMaxConnsPerIP(t *testing.T) {
+	app := New(Options{
+		"listen": "127.0.0.1:0",
+		"server": map[string]interface{}{"max_conns_per_ip": 1},
+	})
+	app.Get("/", func(c *Ctx) { c.SendString("ok") })
+	if err := app.GoListenAndServeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown()
+	addr := app.listeners[0].ln.Addr().String()
+
+	first, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+	get := func(conn net.Conn) error {
+		conn.SetDeadline(time.Now().Add(time.Second))
+		if _, err := io.WriteString(conn, "GET / HTTP/1.1\r\nHost: x\r\n\r\n"); err != nil {
+			return err
+		}
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err == nil {
+			resp.Body.Close()
+		}
+		return err
+	}
+	if err = get(first); err != nil {
+		t.Fatal(err)
+	}
+	second, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = get(second); err == nil {
+		t.Fatalf("second connection of the ip was served")
+	}
+	second.Close()
+
+	// the slot is free once the first connection closes
+	first.Close()
+	time.Sleep(50 * time.Millisecond)
+	third, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer third.Close()
+	if err = get(third); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestBodyReadTimeout(t *testing.T) {
+	app := New(Options{
+		"listen": "127.0.0.1:0",
+		"server": map[string]interface{}{"body_read_timeout": "200ms"},
+	})
+	type result struct {
+		n   int
+		err error
+	}
+	done := make(chan result, 1)
+	app.Post("/", func(c *Ctx) {
+		body, err := io.ReadAll(c.R.Body)
+		done <- result{len(body), err}
+	})
+	if err := app.GoListenAndServeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown()
+
+	conn, err := net.Dial("tcp", app.listeners[0].ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, "POST / HTTP/1.1\r\nHost: x\r\nContent-Length: 10\r\n\r\n")
+	// every part comes within the timeout, the whole body takes longer
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		io.WriteString(conn, "x")
+	}
+	select {
+	case r := <-done:
+		if r.n != 3 || r.err != ErrRequestTimeout {
+			t.Fatalf("read %d bytes, %v", r.n, r.err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the stalled body was not timed out")
+	}
+}
@@ -0,0 +1,291 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/user"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	envListenPID     = "LISTEN_PID"
+	envListenFDs     = "LISTEN_FDS"
+	envListenFDNames = "LISTEN_FDNAMES"
+)
+
+var (
+	ErrNoSystemdSocket = errors.New("listen: no socket passed by systemd")
+	ErrSocketInUse     = errors.New("listen: unix socket in use")
+
+	systemdListeners []inheritedListener
+)
+
+// listen addresses, a list listens on all of them
+//
+//	listen:
+//	  - :8080                       # tcp
+//	  - unix:/run/app/admin.sock    # unix socket
+//	  - systemd                     # every socket of systemd socket activation
+//	  - systemd:admin               # the socket named admin by FileDescriptorName=
+//	unix:
+//	  mode: "0660"
+//	  user: www-data
+//	  group: www-data
+
+// listener implementations of ListenConfig.Type
+const (
+	ListenerNet       = "net"
+	ListenerTCPListen = "tcplisten"
+)
+
+// ListenConfig how tcp addresses are listened on. The net listener applies
+// the socket options before bind but ignores Backlog, tcplisten also sets
+// the backlog. tcplisten and the socket options are linux and bsd only,
+// elsewhere the net listener is used without them. With prefork tcplisten
+// with reuseport, defer_accept and fastopen is the default.
+//
+//	listener:
+//	  type: tcplisten       # net or tcplisten, default net
+//	  backlog: 4096         # default somaxconn
+//	  reuseport: true       # SO_REUSEPORT
+//	  defer_accept: true    # TCP_DEFER_ACCEPT, linux only
+//	  fastopen: true        # TCP_FASTOPEN, linux only
+//	  keepalive: 3m         # keep-alive period, -1 disables, default system
+//	  user_timeout: 30s     # TCP_USER_TIMEOUT, linux only
+//	  read_buffer: 262144   # SO_RCVBUF
+//	  write_buffer: 262144  # SO_SNDBUF
+type ListenConfig struct {
+	Type        string
+	Backlog     int
+	ReusePort   bool
+	DeferAccept bool
+	FastOpen    bool
+	KeepAlive   time.Duration
+	UserTimeout time.Duration
+	ReadBuffer  int
+	WriteBuffer int
+}
+
+// NewListenConfig returns the ListenConfig of the listener section
+func NewListenConfig(conf Options, prefork bool) ListenConfig {
+	def := ListenerNet
+	if prefork {
+		def = ListenerTCPListen
+	}
+	return ListenConfig{
+		Type:        conf.GetString("type", def),
+		Backlog:     conf.GetInt("backlog"),
+		ReusePort:   conf.GetBool("reuseport", prefork),
+		DeferAccept: conf.GetBool("defer_accept", prefork),
+		FastOpen:    conf.GetBool("fastopen", prefork),
+		KeepAlive:   conf.GetDuration("keepalive"),
+		UserTimeout: conf.GetDuration("user_timeout"),
+		ReadBuffer:  conf.GetInt("read_buffer"),
+		WriteBuffer: conf.GetInt("write_buffer"),
+	}
+}
+
+// splitAddr returns the network and address of a listen address
+func splitAddr(addr, network string) (string, string) {
+	switch {
+	case strings.HasPrefix(addr, "unix:"):
+		return "unix", strings.TrimPrefix(addr, "unix:")
+	case addr == "systemd":
+		return "systemd", ""
+	case strings.HasPrefix(addr, "systemd:"):
+		return "systemd", strings.TrimPrefix(addr, "systemd:")
+	}
+	if addr == "" {
+		addr = ":8080"
+	}
+	return network, FixedPort(addr)
+}
+
+// setAddrs replace the listen addresses
+func (c *Core) setAddrs(addrs ...string) {
+	c.addrs = c.addrs[:0]
+	for _, addr := range addrs {
+		if network, a := splitAddr(addr, "tcp"); network == "tcp" {
+			addr = a
+		}
+		c.addrs = append(c.addrs, addr)
+	}
+	if len(c.addrs) > 0 {
+		c.addr = c.addrs[0]
+		c.Server.Addr = c.addr
+	}
+}
+
+// listenAll open the listeners of every listen address, tcp addresses use
+// listen, def is used when none is configured.
+func (c *Core) listenAll(network, def string, listen func(network, addr string) (net.Listener, error)) ([]net.Listener, error) {
+	addrs := c.addrs
+	if len(addrs) == 0 {
+		addrs = []string{def}
+	}
+	lns := make([]net.Listener, 0, len(addrs))
+	for _, addr := range addrs {
+		n, a := splitAddr(addr, network)
+		if n == "systemd" {
+			sl, err := c.listenSystemd(a)
+			if err != nil {
+				closeListeners(lns)
+				return nil, err
+			}
+			lns = append(lns, sl...)
+			continue
+		}
+		fn := listen
+		if n == "unix" {
+			fn = c.listenUnix
+		}
+		ln, err := c.listen(n, a, fn)
+		if err != nil {
+			closeListeners(lns)
+			return nil, err
+		}
+		lns = append(lns, ln)
+	}
+	return lns, nil
+}
+
+func closeListeners(lns []net.Listener) {
+	for _, ln := range lns {
+		ln.Close()
+	}
+}
+
+// listenUnix listen on a unix socket, a stale socket file is removed and
+// the mode and owner of the unix section are applied.
+func (c *Core) listenUnix(network, path string) (net.Listener, error) {
+	if st, err := os.Stat(path); err == nil && st.Mode()&os.ModeSocket != 0 {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %s", ErrSocketInUse, path)
+		}
+		os.Remove(path)
+	}
+	ln, err := net.Listen(network, path)
+	if err != nil {
+		return nil, err
+	}
+	conf := c.Conf.GetMap("unix")
+	if mode := conf.ToString("mode"); mode != "" {
+		m, err := strconv.ParseUint(mode, 8, 32)
+		if err == nil {
+			err = os.Chmod(path, os.FileMode(m))
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen: unix mode %s: %w", mode, err)
+		}
+	}
+	uid, gid := -1, -1
+	if name := conf.GetString("user"); name != "" {
+		u, err := user.Lookup(name)
+		if err == nil {
+			uid, err = strconv.Atoi(u.Uid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen: unix user %s: %w", name, err)
+		}
+	}
+	if name := conf.GetString("group"); name != "" {
+		g, err := user.LookupGroup(name)
+		if err == nil {
+			gid, err = strconv.Atoi(g.Gid)
+		}
+		if err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen: unix group %s: %w", name, err)
+		}
+	}
+	if uid != -1 || gid != -1 {
+		if err := os.Chown(path, uid, gid); err != nil {
+			ln.Close()
+			return nil, fmt.Errorf("listen: unix owner: %w", err)
+		}
+	}
+	return ln, nil
+}
+
+// listenSystemd returns the sockets passed by systemd socket activation with
+// name, all of them when name is empty. After an upgrade they are inherited
+// from the parent process.
+func (c *Core) listenSystemd(name string) ([]net.Listener, error) {
+	key := "systemd"
+	if name != "" {
+		key += ":" + name
+	}
+	var found []inheritedListener
+	for il, ok := takeInherited("systemd", key); ok; il, ok = takeInherited("systemd", key) {
+		found = append(found, il)
+	}
+	if len(found) == 0 {
+		inheritMu.Lock()
+		loadSystemdListeners()
+		rest := systemdListeners[:0]
+		for _, sl := range systemdListeners {
+			if name == "" || sl.key == key {
+				found = append(found, sl)
+			} else {
+				rest = append(rest, sl)
+			}
+		}
+		systemdListeners = rest
+		inheritMu.Unlock()
+	}
+	if len(found) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNoSystemdSocket, key)
+	}
+	lns := make([]net.Listener, 0, len(found))
+	c.life.mu.Lock()
+	for _, sl := range found {
+		c.listeners = append(c.listeners, sl)
+		lns = append(lns, sl.ln)
+	}
+	c.life.mu.Unlock()
+	return lns, nil
+}
+
+var systemdLoaded bool
+
+// loadSystemdListeners read the sockets of LISTEN_FDS once, see sd_listen_fds(3)
+func loadSystemdListeners() {
+	if systemdLoaded {
+		return
+	}
+	systemdLoaded = true
+	defer func() {
+		os.Unsetenv(envListenPID)
+		os.Unsetenv(envListenFDs)
+		os.Unsetenv(envListenFDNames)
+	}()
+	if pid, err := strconv.Atoi(os.Getenv(envListenPID)); err != nil || pid != os.Getpid() {
+		return
+	}
+	n, err := strconv.Atoi(os.Getenv(envListenFDs))
+	if err != nil {
+		return
+	}
+	names := strings.Split(os.Getenv(envListenFDNames), ":")
+	for i := 0; i < n; i++ {
+		key := "systemd"
+		if i < len(names) && names[i] != "" {
+			key += ":" + names[i]
+		}
+		f := os.NewFile(uintptr(3+i), key)
+		ln, err := net.FileListener(f)
+		f.Close()
+		if err != nil {
+			Erro("listen: systemd fd %d: %s", 3+i, err)
+			continue
+		}
+		systemdListeners = append(systemdListeners, inheritedListener{key: key, ln: ln})
+	}
+}
@@ -0,0 +1,18 @@
+//go:build !linux && !darwin && !dragonfly && !freebsd && !netbsd && !openbsd && !rumprun
+// +build !linux,!darwin,!dragonfly,!freebsd,!netbsd,!openbsd,!rumprun
+
+package core
+
+import (
+	"net"
+
+	"github.com/xs23933/core/reuseport"
+)
+
+// listen open a tcp listener, only reuseport is supported on this platform
+func (lc ListenConfig) listen(network, addr string) (net.Listener, error) {
+	if lc.ReusePort {
+		return reuseport.Listen(network, addr)
+	}
+	return net.Listen(network, addr)
+}
@@ -0,0 +1,33 @@
+//go:build linux || darwin || dragonfly || freebsd || netbsd || openbsd || rumprun
+// +build linux darwin dragonfly freebsd netbsd openbsd rumprun
+
+package core
+
+import (
+	"context"
+	"net"
+
+	"github.com/xs23933/core/reuseport"
+	"github.com/xs23933/core/tcplisten"
+)
+
+// listen open a tcp listener with the implementation and socket options of lc
+func (lc ListenConfig) listen(network, addr string) (net.Listener, error) {
+	cfg := &tcplisten.Config{
+		ReusePort:   lc.ReusePort,
+		DeferAccept: lc.DeferAccept,
+		FastOpen:    lc.FastOpen,
+		Backlog:     lc.Backlog,
+		UserTimeout: lc.UserTimeout,
+		RecvBuffer:  lc.ReadBuffer,
+		SendBuffer:  lc.WriteBuffer,
+	}
+	if lc.Type == ListenerTCPListen {
+		if lc.ReusePort {
+			return reuseport.ListenConfig(cfg, network, addr)
+		}
+		return cfg.NewListener(network, addr)
+	}
+	nlc := net.ListenConfig{Control: cfg.Control}
+	return nlc.Listen(context.Background(), network, addr)
+}
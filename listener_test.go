@@ -0,0 +1,85 @@
+package core
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestListenUnixAndTCP(t *testing.T) {
+	sock := filepath.Join(t.TempDir(), "app.sock")
+	app := New(Options{
+		"listen": []interface{}{"127.0.0.1:0", "unix:" + sock},
+		"unix":   map[string]interface{}{"mode": "0600"},
+	})
+	app.Get("/", func(c *Ctx) { c.SendString("ok") })
+	if err := app.GoListenAndServeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer app.Shutdown()
+
+	st, err := os.Stat(sock)
+	if err != nil || st.Mode().Perm() != 0600 {
+		t.Fatalf("unexpected socket mode %v %v", st, err)
+	}
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return net.Dial("unix", sock)
+		},
+	}}
+	addr := "http://" + app.listeners[0].ln.Addr().String()
+	for _, get := range []func(string) (*http.Response, error){client.Get, http.Get} {
+		resp, err := get(addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if string(body) != "ok" {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+
+	// a live socket is not replaced
+	other := New(Options{"listen": "unix:" + sock})
+	if _, err = other.listenAll("tcp", "", net.Listen); err == nil {
+		t.Fatalf("expected socket in use error")
+	}
+}
+
+func TestListenConfig(t *testing.T) {
+	for _, typ := range []string{ListenerNet, ListenerTCPListen} {
+		app := New(Options{
+			"listen": "127.0.0.1:0",
+			"listener": map[string]interface{}{
+				"type":        typ,
+				"backlog":     64,
+				"reuseport":   true,
+				"keepalive":   "30s",
+				"read_buffer": 65536,
+			},
+		})
+		if app.ListenConfig.Type != typ || app.ListenConfig.KeepAlive != 30*time.Second {
+			t.Fatalf("unexpected config %+v", app.ListenConfig)
+		}
+		app.Get("/", func(c *Ctx) { c.SendString(typ) })
+		if err := app.GoListenAndServeContext(context.Background()); err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.Get("http://" + app.listeners[0].ln.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		app.Shutdown()
+		if string(body) != typ {
+			t.Fatalf("unexpected body %q", body)
+		}
+	}
+}
@@ -28,18 +28,36 @@ type canStartModule interface {
 	Start(*Engine) error
 }
 
+// canStopModule is stopped by a Shutdown hook after requests are drained
+type canStopModule interface {
+	Stop(context.Context) error
+}
+
 type hasHandler interface {
 	Preload(*Ctx)
 }
 
+// Shutdown stop the server gracefully, modules are stopped after the
+// requests are drained, see Core.ShutdownContext
 func (e *Engine) Shutdown() error {
-	if e.stop != nil {
-		e.stop()
-	}
-	e.EG.Wait()
 	return e.Core.Shutdown()
 }
 
+// stopModules cancel the engine context and wait for the started modules
+func (e *Engine) stopModules(ctx context.Context) error {
+	e.stop()
+	done := make(chan error, 1)
+	go func() {
+		done <- e.EG.Wait()
+	}()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
 func NewEngine(conf ...Options) *Engine {
 	engine := &Engine{
 		Core: Default(conf...),
@@ -47,6 +65,7 @@ func NewEngine(conf ...Options) *Engine {
 	ctx, cancel := context.WithCancel(context.Background())
 	engine.EG, engine.Ctx = errgroup.WithContext(ctx)
 	engine.stop = cancel
+	engine.OnShutdown("modules", engine.stopModules)
 	//创建监听退出chan
 	c := make(chan os.Signal, 1)
 	//监听指定信号 ctrl+c kill
@@ -54,10 +73,34 @@ func NewEngine(conf ...Options) *Engine {
 	signal.Notify(c, syscall.SIGHUP, syscall.SIGINT, syscall.SIGTERM,
 		syscall.SIGQUIT, SIGUSR2)
 	go func() {
-		for range c {
-			cancel()
-			engine.Server.Shutdown(ctx)
+	wait:
+		for sig := range c {
+			switch sig {
+			case SIGUSR2: // hand the listeners to a new process then drain
+				if err := engine.Upgrade(); err != nil {
+					Erro("%s", err)
+					continue
+				}
+			case syscall.SIGHUP: // rolling restart of the prefork workers
+				if engine.Workers() != nil {
+					go func() {
+						if err := engine.RestartWorkers(); err != nil {
+							Erro("%s", err)
+						}
+					}()
+					continue
+				}
+			}
+			break wait
 		}
+		go func() {
+			if err := engine.Shutdown(); err != nil {
+				Erro("%s", err)
+			}
+		}()
+		// a second signal force close
+		<-c
+		engine.Server.Close()
 	}()
 	return engine
 }
@@ -65,6 +108,9 @@ func NewEngine(conf ...Options) *Engine {
 func (e *Engine) loadMods() {
 	for _, m := range GetModules("module") {
 		mo := m.Instance()
+		if mod, ok := mo.(canStopModule); ok {
+			e.OnShutdown(m.ID, mod.Stop)
+		}
 		if mod, ok := mo.(canStartModule); ok {
 			e.EG.Go(func() error {
 				select {
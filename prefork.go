@@ -0,0 +1,498 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const envWorkerID = "CORE_WORKER_ID"
+
+// worker states
+const (
+	WorkerStarting = "starting"
+	WorkerRunning  = "running"
+	WorkerStopping = "stopping"
+	WorkerExited   = "exited"
+)
+
+var (
+	ErrNotPreforkMaster = errors.New("prefork: not the prefork master")
+	ErrTooManyRestarts  = errors.New("prefork: workers restart too often")
+)
+
+// WorkerStatus state of a prefork worker seen by the master
+type WorkerStatus struct {
+	ID       int
+	Pid      int
+	State    string
+	Started  time.Time
+	Restarts int
+	LastExit string
+}
+
+// preforkConfig
+//
+//	prefork: true                 # or a section
+//	prefork:
+//	  workers: 4                  # default GOMAXPROCS
+//	  backoff: 1s                 # first respawn delay, doubles on each crash
+//	  max_backoff: 30s
+//	  max_restarts: 10            # restarts allowed in restart_window, then the master exits
+//	  restart_window: 1m
+//	  ready_timeout: 30s          # rolling restart wait for a new worker
+type preforkConfig struct {
+	workers      int
+	backoff      time.Duration
+	maxBackoff   time.Duration
+	maxRestarts  int
+	window       time.Duration
+	readyTimeout time.Duration
+}
+
+func newPreforkConfig(conf Options) preforkConfig {
+	return preforkConfig{
+		workers:      conf.GetInt("workers", runtime.GOMAXPROCS(0)),
+		backoff:      conf.GetDuration("backoff", time.Second),
+		maxBackoff:   conf.GetDuration("max_backoff", 30*time.Second),
+		maxRestarts:  conf.GetInt("max_restarts", 10),
+		window:       conf.GetDuration("restart_window", time.Minute),
+		readyTimeout: conf.GetDuration("ready_timeout", 30*time.Second),
+	}
+}
+
+type worker struct {
+	status   WorkerStatus
+	cmd      *exec.Cmd
+	failures int
+	ready    chan struct{}
+	done     chan struct{}
+	ipc      net.Conn
+}
+
+// supervisor start the prefork workers, respawn them when they crash and
+// restart them one by one on demand
+type supervisor struct {
+	c        *Core
+	conf     preforkConfig
+	files    []*os.File
+	keys     []string
+	mu       sync.Mutex
+	rolling  sync.Mutex
+	workers  []*worker
+	restarts []time.Time
+	stopping bool
+	exits    chan *worker
+	quit     chan struct{} // closed once run returned
+}
+
+// WorkerID returns the slot of this prefork worker, -1 in other processes
+func WorkerID() int {
+	if id, err := strconv.Atoi(os.Getenv(envWorkerID)); err == nil && IsChild() {
+		return id
+	}
+	return -1
+}
+
+func (c *Core) prefork() error {
+	if IsChild() {
+		// use 1 cpu core per child process
+		runtime.GOMAXPROCS(1)
+		lns, err := c.listenAll(c.networkProto, c.addr, c.ListenConfig.listen)
+		if err != nil {
+			time.Sleep(sleepDuration)
+			return fmt.Errorf("prefork: %w", err)
+		}
+		// kill current child proc when master exited
+		go watchMaster()
+		c.ipc.connectMaster()
+
+		return c.serveAll(lns)
+	}
+
+	s := &supervisor{
+		c:     c,
+		conf:  newPreforkConfig(c.Conf.GetMap("prefork")),
+		exits: make(chan *worker, 16),
+		quit:  make(chan struct{}),
+	}
+	// workers share the listener of the master, it is passed on upgrade.
+	// windows can not pass it, each worker listens itself.
+	if runtime.GOOS != "windows" {
+		if _, err := c.listenAll(c.networkProto, c.addr, c.ListenConfig.listen); err != nil {
+			return fmt.Errorf("prefork: %w", err)
+		}
+		files, keys, err := c.listenerFiles()
+		if err != nil {
+			return fmt.Errorf("prefork: %w", err)
+		}
+		s.files, s.keys = files, keys
+		defer closeFiles(files)
+	}
+	// kill child procs when master exists
+	defer s.kill()
+
+	s.workers = make([]*worker, s.conf.workers)
+	pids := make([]string, 0, s.conf.workers)
+	for i := range s.workers {
+		w, err := s.spawn(i, 0)
+		if err != nil {
+			return err
+		}
+		pids = append(pids, strconv.Itoa(w.status.Pid))
+	}
+	c.life.mu.Lock()
+	c.supervisor = s
+	c.life.mu.Unlock()
+	Info("start childs %s", strings.Join(pids, ","))
+	notifyReady()
+
+	stop := make(chan struct{})
+	c.Server.RegisterOnShutdown(func() { close(stop) })
+	c.OnShutdown("prefork", s.stop)
+	return s.run(stop)
+}
+
+// run respawn the crashed workers until stop
+func (s *supervisor) run(stop chan struct{}) error {
+	defer close(s.quit)
+	for {
+		select {
+		case <-stop:
+			s.c.waitShutdown()
+			return http.ErrServerClosed
+		case w := <-s.exits:
+			if !s.current(w) {
+				continue
+			}
+			if err := s.allowRestart(); err != nil {
+				return err
+			}
+			delay := s.conf.backoff << uint(w.failures)
+			if delay > s.conf.maxBackoff || delay <= 0 {
+				delay = s.conf.maxBackoff
+			}
+			Warn("prefork: worker %d pid %d exited: %s, restart in %s", w.status.ID, w.status.Pid, w.status.LastExit, delay)
+			failures := w.failures + 1
+			if time.Since(w.status.Started) > s.conf.window {
+				failures = 0
+			}
+			time.AfterFunc(delay, func() {
+				if !s.current(w) {
+					return
+				}
+				if _, err := s.spawn(w.status.ID, failures); err != nil {
+					Erro("%s", err)
+					w.failures = failures
+					s.exited(w)
+				}
+			})
+		}
+	}
+}
+
+// allowRestart record a restart and fail when they exceed max_restarts
+func (s *supervisor) allowRestart() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := time.Now()
+	restarts := s.restarts[:0]
+	for _, t := range s.restarts {
+		if now.Sub(t) < s.conf.window {
+			restarts = append(restarts, t)
+		}
+	}
+	s.restarts = append(restarts, now)
+	if len(s.restarts) > s.conf.maxRestarts {
+		return ErrTooManyRestarts
+	}
+	return nil
+}
+
+// current reports whether w still owns its slot and the master is not stopping
+func (s *supervisor) current(w *worker) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return !s.stopping && s.workers[w.status.ID] == w
+}
+
+// exited hand w to run, dropped once run returned
+func (s *supervisor) exited(w *worker) {
+	select {
+	case s.exits <- w:
+	case <-s.quit:
+	}
+}
+
+// spawn start a worker in slot id, it replaces the one in the slot
+func (s *supervisor) spawn(id, failures int) (*worker, error) {
+	w, err := s.start(id, failures)
+	if err != nil {
+		return nil, err
+	}
+	if !s.install(w) {
+		s.discard(w)
+		return nil, http.ErrServerClosed
+	}
+	return w, nil
+}
+
+// start a worker for slot id, it is not in the slot until installed
+func (s *supervisor) start(id, failures int) (*worker, error) {
+	cmd := exec.Command(os.Args[0], os.Args[1:]...) // nolint:gosec
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = s.files
+	env := []string{
+		fmt.Sprintf("%s=%s", envPreforkChildKey, envPreforkChildVal),
+		fmt.Sprintf("%s=%d", envWorkerID, id),
+	}
+	w := &worker{
+		cmd:      cmd,
+		failures: failures,
+		ready:    make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+
+	// the worker closes the pipe once it serves and talks to the master over
+	// a socketpair, windows can not pass them
+	var (
+		r, wp, ipcFile *os.File
+		ipcConn        net.Conn
+	)
+	if runtime.GOOS != "windows" {
+		var err error
+		if r, wp, err = os.Pipe(); err != nil {
+			return nil, fmt.Errorf("prefork: %w", err)
+		}
+		if ipcConn, ipcFile, err = socketpair(); err != nil {
+			r.Close()
+			wp.Close()
+			return nil, err
+		}
+		cmd.ExtraFiles = append(append([]*os.File{}, s.files...), wp, ipcFile)
+		env = append(env,
+			fmt.Sprintf("%s=%d", envReadyFD, 3+len(s.files)),
+			fmt.Sprintf("%s=%d", envIPCFD, 4+len(s.files)))
+	}
+	cmd.Env = inheritEnv(s.keys, env...)
+
+	err := cmd.Start()
+	s.c.restoreNonblock()
+	if wp != nil {
+		wp.Close()
+		ipcFile.Close()
+	}
+	if err != nil {
+		if r != nil {
+			r.Close()
+			ipcConn.Close()
+		}
+		return nil, fmt.Errorf("failed to start a child prefork process, error: %w", err)
+	}
+	w.ipc = ipcConn
+	w.status = WorkerStatus{ID: id, Pid: cmd.Process.Pid, State: WorkerStarting, Started: time.Now()}
+
+	go func() {
+		if r != nil {
+			_, err := r.Read(make([]byte, 1))
+			r.Close()
+			if err != nil {
+				return
+			}
+		}
+		s.setState(w, WorkerRunning)
+		close(w.ready)
+	}()
+	go func() {
+		err := cmd.Wait()
+		s.mu.Lock()
+		w.status.State = WorkerExited
+		if err != nil {
+			w.status.LastExit = err.Error()
+		} else {
+			w.status.LastExit = "exit status 0"
+		}
+		s.mu.Unlock()
+		close(w.done)
+		s.exited(w)
+	}()
+	return w, nil
+}
+
+// install put w in its slot in place of the worker there, false once the
+// master is stopping
+func (s *supervisor) install(w *worker) bool {
+	id := w.status.ID
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.stopping {
+		return false
+	}
+	if w.ipc != nil {
+		s.c.ipc.attach(id, w.ipc)
+	}
+	if prev := s.workers[id]; prev != nil {
+		w.status.Restarts = prev.status.Restarts + 1
+	}
+	s.workers[id] = w
+	return true
+}
+
+// discard kill a worker that never got its slot
+func (s *supervisor) discard(w *worker) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.conf.readyTimeout)
+	defer cancel()
+	if err := s.terminate(ctx, w); err != nil {
+		Warn("%s", err)
+	}
+	if w.ipc != nil {
+		w.ipc.Close()
+	}
+}
+
+func (s *supervisor) setState(w *worker, state string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w.status.State != WorkerExited {
+		w.status.State = state
+	}
+}
+
+// terminate ask the worker to drain, kill it when ctx is done
+func (s *supervisor) terminate(ctx context.Context, w *worker) error {
+	s.setState(w, WorkerStopping)
+	if err := w.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+		w.cmd.Process.Kill()
+	}
+	select {
+	case <-w.done:
+		return nil
+	case <-ctx.Done():
+		w.cmd.Process.Kill()
+		return fmt.Errorf("prefork: worker %d pid %d killed: %w", w.status.ID, w.status.Pid, ctx.Err())
+	}
+}
+
+// stop forward SIGTERM to the workers and wait for them to drain
+func (s *supervisor) stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopping = true
+	workers := append([]*worker{}, s.workers...)
+	s.mu.Unlock()
+	errs := make(chan error, len(workers))
+	for _, w := range workers {
+		go func(w *worker) {
+			errs <- s.terminate(ctx, w)
+		}(w)
+	}
+	var err error
+	for range workers {
+		if e := <-errs; e != nil {
+			err = e
+		}
+	}
+	return err
+}
+
+func (s *supervisor) kill() {
+	s.mu.Lock()
+	s.stopping = true
+	defer s.mu.Unlock()
+	for _, w := range s.workers {
+		if w == nil {
+			continue
+		}
+		if err := w.cmd.Process.Kill(); err != nil && !errors.Is(err, os.ErrProcessDone) {
+			Erro("prefork: failed to kill child: %v", err)
+		}
+	}
+}
+
+// restart replace the workers one at a time, each new worker must serve
+// before it takes the slot and the old one is drained. A new worker failing
+// to serve is discarded and the old one kept.
+func (s *supervisor) restart() error {
+	s.rolling.Lock()
+	defer s.rolling.Unlock()
+	for id := range s.workers {
+		s.mu.Lock()
+		old := s.workers[id]
+		stopping := s.stopping
+		s.mu.Unlock()
+		if stopping {
+			return http.ErrServerClosed
+		}
+		w, err := s.start(id, 0)
+		if err != nil {
+			return err
+		}
+		timer := time.NewTimer(s.conf.readyTimeout)
+		select {
+		case <-w.ready:
+			timer.Stop()
+		case <-w.done:
+			timer.Stop()
+			s.discard(w)
+			s.mu.Lock()
+			exit := w.status.LastExit
+			s.mu.Unlock()
+			return fmt.Errorf("prefork: worker %d exited before ready: %s", id, exit)
+		case <-timer.C:
+			s.discard(w)
+			return fmt.Errorf("prefork: worker %d not ready in time", id)
+		}
+		if !s.install(w) {
+			s.discard(w)
+			return http.ErrServerClosed
+		}
+		if old != nil {
+			ctx, cancel := context.WithTimeout(context.Background(), s.c.Conf.GetDuration("shutdown_timeout", 30*time.Second))
+			err = s.terminate(ctx, old)
+			cancel()
+			if err != nil {
+				Warn("%s", err)
+			}
+		}
+	}
+	return nil
+}
+
+// Workers returns the status of the prefork workers, only in the master
+func (c *Core) Workers() []WorkerStatus {
+	c.life.mu.Lock()
+	s := c.supervisor
+	c.life.mu.Unlock()
+	if s == nil {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := make([]WorkerStatus, 0, len(s.workers))
+	for _, w := range s.workers {
+		status = append(status, w.status)
+	}
+	return status
+}
+
+// RestartWorkers gracefully restart the prefork workers one at a time, a new
+// worker serves before the old one drains. NewEngine calls it on SIGHUP.
+func (c *Core) RestartWorkers() error {
+	c.life.mu.Lock()
+	s := c.supervisor
+	c.life.mu.Unlock()
+	if s == nil {
+		return ErrNotPreforkMaster
+	}
+	return s.restart()
+}
@@ -0,0 +1,127 @@
+package core
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func waitWorkers(t *testing.T, app *Core, ok func([]WorkerStatus) bool) []WorkerStatus {
+	for i := 0; i < 200; i++ {
+		if status := app.Workers(); status != nil && ok(status) {
+			return status
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("unexpected workers %+v", app.Workers())
+	return nil
+}
+
+func allRunning(status []WorkerStatus) bool {
+	for _, w := range status {
+		if w.State != WorkerRunning {
+			return false
+		}
+	}
+	return true
+}
+
+// TestPrefork runs as the master and again in each worker
+func TestPrefork(t *testing.T) {
+	if IsChild() {
+		app := New(Options{"listen": os.Getenv("CORE_TEST_PREFORK_ADDR"), "prefork": true})
+		app.Get("/", func(c *Ctx) { c.SendString("worker") })
+		app.IPC().SetMetrics(func() map[string]float64 { return map[string]float64{"up": 1} })
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, syscall.SIGTERM)
+		go func() {
+			<-sig
+			app.Shutdown()
+		}()
+		app.ListenAndServe()
+		return
+	}
+	ln, err := net.Listen("tcp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestPrefork$"}
+	os.Setenv("CORE_TEST_PREFORK_ADDR", addr)
+	defer func() {
+		os.Args = args
+		os.Unsetenv("CORE_TEST_PREFORK_ADDR")
+	}()
+
+	// every wait is bounded, a stuck worker fails the test instead of hanging it
+	app := New(Options{
+		"listen":           addr,
+		"shutdown_timeout": "5s",
+		"prefork":          Options{"workers": 2, "backoff": "10ms", "ready_timeout": "5s"},
+	})
+	served := make(chan error, 1)
+	go func() { served <- app.ListenAndServe() }()
+
+	status := waitWorkers(t, app, allRunning)
+	if len(status) != 2 {
+		t.Fatalf("expected 2 workers, got %+v", status)
+	}
+	client := &http.Client{Timeout: 5 * time.Second}
+	resp, err := client.Get("http://" + addr)
+	if err != nil || resp.StatusCode != StatusOK {
+		t.Fatalf("workers do not serve: %v", err)
+	}
+	resp.Body.Close()
+	client.CloseIdleConnections()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if m, err := app.IPC().Metrics(ctx); err != nil || m.Total["up"] != 2 {
+		t.Fatalf("unexpected worker metrics %+v %v", m, err)
+	}
+
+	// a crashed worker is respawned
+	crashed := status[0].Pid
+	if proc, err := os.FindProcess(crashed); err == nil {
+		proc.Kill()
+	}
+	waitWorkers(t, app, func(s []WorkerStatus) bool {
+		return s[0].Pid != crashed && s[0].Restarts == 1 && allRunning(s)
+	})
+
+	// rolling restart replaces every worker
+	before := app.Workers()
+	if err = app.RestartWorkers(); err != nil {
+		t.Fatal(err)
+	}
+	for i, w := range app.Workers() {
+		if w.Pid == before[i].Pid || w.State != WorkerRunning {
+			t.Fatalf("worker %d not restarted: %+v", i, w)
+		}
+	}
+
+	if err = app.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err = <-served:
+		if err != http.ErrServerClosed {
+			t.Fatalf("unexpected prefork result %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("prefork master did not return")
+	}
+	for _, w := range app.Workers() {
+		if w.State != WorkerExited {
+			t.Fatalf("worker not stopped: %+v", w)
+		}
+	}
+}
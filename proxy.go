@@ -0,0 +1,240 @@
+package core
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// trusted proxies, their forwarding headers are used by RemoteIP, Scheme and
+// Host. None is trusted by default so the headers sent by clients are
+// ignored. unix trusts the clients of unix sockets.
+//
+//	trusted_proxies:
+//	  - 10.0.0.0/8
+//	  - 127.0.0.1
+//	  - unix
+//	remote_ip_headers:      # default Forwarded, X-Forwarded-For, X-Real-IP
+//	  - X-Forwarded-For
+
+// SetTrustedProxies replace the trusted proxies, an ip, a CIDR or unix
+func (c *Core) SetTrustedProxies(proxies ...string) error {
+	cidrs := make([]*net.IPNet, 0, len(proxies))
+	unix := false
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if p == "unix" {
+			unix = true
+			continue
+		}
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return fmt.Errorf("trusted_proxies: invalid ip %q", p)
+			}
+			bits := 32
+			if ip.To4() == nil {
+				bits = 128
+			}
+			p = fmt.Sprintf("%s/%d", p, bits)
+		}
+		_, cidr, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("trusted_proxies: %w", err)
+		}
+		cidrs = append(cidrs, cidr)
+	}
+	c.trustedCIDRs, c.trustUnix = cidrs, unix
+	return nil
+}
+
+// IsTrustedProxy reports whether ip is a trusted proxy
+func (c *Core) IsTrustedProxy(ip net.IP) bool {
+	for _, cidr := range c.trustedCIDRs {
+		if cidr.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// peerIP returns the ip of the connection, nil for unix sockets
+func (c *Ctx) peerIP() net.IP {
+	ip, _, err := net.SplitHostPort(strings.TrimSpace(c.R.RemoteAddr))
+	if err != nil {
+		return nil
+	}
+	return net.ParseIP(ip)
+}
+
+// fromTrustedProxy reports whether the request was sent by a trusted proxy
+func (c *Ctx) fromTrustedProxy() bool {
+	if ip := c.peerIP(); ip != nil {
+		return c.core.IsTrustedProxy(ip)
+	}
+	return c.core.trustUnix
+}
+
+// RemoteIP returns the ip of the client. When the request comes from a
+// trusted proxy the RemoteIPHeaders are read right to left, the first ip
+// which is not a trusted proxy is the client.
+func (c *Ctx) RemoteIP() net.IP {
+	peer := c.peerIP()
+	if !c.fromTrustedProxy() {
+		return peer
+	}
+	for _, name := range c.core.RemoteIPHeaders {
+		var chain []string
+		if http.CanonicalHeaderKey(name) == HeaderForwarded {
+			for _, elem := range parseForwarded(c.R.Header.Values(HeaderForwarded)) {
+				chain = append(chain, elem["for"])
+			}
+		} else {
+			for _, v := range c.R.Header.Values(name) {
+				chain = append(chain, strings.Split(v, ",")...)
+			}
+		}
+		if ip := c.clientIP(chain); ip != nil {
+			return ip
+		}
+	}
+	return peer
+}
+
+// clientIP walks a proxy chain right to left, nil when an entry is invalid
+func (c *Ctx) clientIP(chain []string) net.IP {
+	var ip net.IP
+	for i := len(chain) - 1; i >= 0; i-- {
+		ip = parseNodeIP(chain[i])
+		if ip == nil {
+			return nil
+		}
+		if !c.core.IsTrustedProxy(ip) {
+			return ip
+		}
+	}
+	// every hop is trusted, the leftmost is the client
+	return ip
+}
+
+// parseNodeIP parse the ip of an X-Forwarded-For entry or a Forwarded node,
+// which may be quoted and carry a port: "[2001:db8::1]:4711"
+func parseNodeIP(node string) net.IP {
+	node = strings.Trim(strings.TrimSpace(node), `"`)
+	if strings.HasPrefix(node, "[") {
+		if end := strings.IndexByte(node, ']'); end > 0 {
+			return net.ParseIP(node[1:end])
+		}
+		return nil
+	}
+	if strings.Count(node, ":") == 1 {
+		node, _, _ = strings.Cut(node, ":")
+	}
+	return net.ParseIP(node)
+}
+
+// parseForwarded parse RFC 7239 Forwarded headers into their elements,
+// parameter names are lower case and quotes are removed
+func parseForwarded(values []string) []map[string]string {
+	var elems []map[string]string
+	for _, v := range values {
+		for _, e := range splitQuoted(v, ',') {
+			elem := make(map[string]string)
+			for _, pair := range splitQuoted(e, ';') {
+				k, val, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok {
+					continue
+				}
+				elem[strings.ToLower(k)] = strings.Trim(val, `"`)
+			}
+			if len(elem) > 0 {
+				elems = append(elems, elem)
+			}
+		}
+	}
+	return elems
+}
+
+// splitQuoted split s at sep outside of quoted strings
+func splitQuoted(s string, sep byte) []string {
+	var parts []string
+	quoted, start := false, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case '\\':
+			if quoted {
+				i++
+			}
+		case sep:
+			if !quoted {
+				parts = append(parts, s[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// forwarded returns the first value of a forwarding header sent by a
+// trusted proxy, param of Forwarded or else the header
+func (c *Ctx) forwarded(param, header string) string {
+	if !c.fromTrustedProxy() {
+		return ""
+	}
+	for _, elem := range parseForwarded(c.R.Header.Values(HeaderForwarded)) {
+		if v := elem[param]; v != "" {
+			return v
+		}
+	}
+	v, _, _ := strings.Cut(c.GetHeader(header), ",")
+	return strings.TrimSpace(v)
+}
+
+// Scheme returns the scheme of the client request, http or https. Behind a
+// trusted proxy it is taken from Forwarded, X-Forwarded-Proto or X-Forwarded-Ssl.
+func (c *Ctx) Scheme() string {
+	if proto := c.forwarded("proto", HeaderXForwardedProto); proto != "" {
+		return strings.ToLower(proto)
+	}
+	if c.fromTrustedProxy() && strings.EqualFold(c.GetHeader(HeaderXForwardedSsl), "on") {
+		return "https"
+	}
+	if c.R.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// Host returns the host requested by the client. Behind a trusted proxy it
+// is taken from Forwarded or X-Forwarded-Host.
+func (c *Ctx) Host() string {
+	if host := c.forwarded("host", HeaderXForwardedHost); host != "" {
+		return host
+	}
+	return c.R.Host
+}
+
+// Protocol returns the HTTP version spoken by the client e.g HTTP/1.1.
+// Behind a trusted proxy it is taken from the first received-protocol of
+// Via, as "2 cdn, 1.1 lb" gives HTTP/2.0.
+func (c *Ctx) Protocol() string {
+	if !c.fromTrustedProxy() {
+		return c.R.Proto
+	}
+	first, _, _ := strings.Cut(c.GetHeader(HeaderVia), ",")
+	received, _, _ := strings.Cut(strings.TrimSpace(first), " ")
+	if received == "" {
+		return c.R.Proto
+	}
+	name, version, ok := strings.Cut(received, "/")
+	if !ok {
+		name, version = "HTTP", received
+	}
+	if !strings.Contains(version, ".") {
+		version += ".0"
+	}
+	return strings.ToUpper(name) + "/" + version
+}
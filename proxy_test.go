@@ -0,0 +1,53 @@
+package core
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTrustedProxies(t *testing.T) {
+	app := New(Options{"trusted_proxies": []interface{}{"10.0.0.0/8", "127.0.0.1"}})
+	for _, tc := range []struct {
+		remote  string
+		headers map[string]string
+		ip      string
+		scheme  string
+		host    string
+		proto   string
+	}{
+		// headers of untrusted peers are ignored
+		{"203.0.113.9:1234", map[string]string{"X-Forwarded-For": "1.2.3.4", "X-Forwarded-Proto": "https"}, "203.0.113.9", "http", "example.com", "HTTP/1.1"},
+		// the chain is read right to left skipping trusted proxies
+		{"127.0.0.1:1234", map[string]string{"X-Forwarded-For": "6.6.6.6, 1.2.3.4, 10.0.0.2"}, "1.2.3.4", "http", "example.com", "HTTP/1.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "10.0.0.3, 10.0.0.2"}, "10.0.0.3", "http", "example.com", "HTTP/1.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-For": "bogus", "X-Real-IP": "1.2.3.4"}, "1.2.3.4", "http", "example.com", "HTTP/1.1"},
+		{"10.0.0.1:1234", map[string]string{
+			"Forwarded": `for=192.0.2.60;proto=https;host=app.example.org, for="[2001:db8:cafe::17]:4711"`,
+		}, "2001:db8:cafe::17", "https", "app.example.org", "HTTP/1.1"},
+		{"10.0.0.1:1234", map[string]string{"X-Forwarded-Host": "a.example.org, b", "X-Forwarded-Ssl": "on"}, "10.0.0.1", "https", "a.example.org", "HTTP/1.1"},
+		// the protocol of the client is the first one of Via
+		{"203.0.113.9:1234", map[string]string{"Via": "2 cdn"}, "203.0.113.9", "http", "example.com", "HTTP/1.1"},
+		{"10.0.0.1:1234", map[string]string{"Via": "2 cdn, 1.1 lb"}, "10.0.0.1", "http", "example.com", "HTTP/2.0"},
+		{"10.0.0.1:1234", map[string]string{"Via": "HTTP/1.0 squid"}, "10.0.0.1", "http", "example.com", "HTTP/1.0"},
+	} {
+		req := httptest.NewRequest("GET", "http://example.com/", nil)
+		req.RemoteAddr = tc.remote
+		for k, v := range tc.headers {
+			req.Header.Set(k, v)
+		}
+		ctx := app.assignCtx(httptest.NewRecorder(), req)
+		if ip := ctx.RemoteIP().String(); ip != tc.ip {
+			t.Errorf("%s %v: ip %s, want %s", tc.remote, tc.headers, ip, tc.ip)
+		}
+		if scheme := ctx.Scheme(); scheme != tc.scheme {
+			t.Errorf("%s %v: scheme %s, want %s", tc.remote, tc.headers, scheme, tc.scheme)
+		}
+		if host := ctx.Host(); host != tc.host {
+			t.Errorf("%s %v: host %s, want %s", tc.remote, tc.headers, host, tc.host)
+		}
+		if proto := ctx.Protocol(); proto != tc.proto {
+			t.Errorf("%s %v: protocol %s, want %s", tc.remote, tc.headers, proto, tc.proto)
+		}
+		app.releaseCtx(ctx)
+	}
+}
@@ -0,0 +1,274 @@
+package core
+
+import (
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Rate limit algorithms
+const (
+	TokenBucket   = "token_bucket"   // Limit per Window refilled continuously, Burst at once
+	SlidingWindow = "sliding_window" // Limit per Window weighted over the previous one
+)
+
+// RateLimitConfig options of the RateLimit middleware
+//
+//	rate_limit:
+//	  algorithm: token_bucket   # or sliding_window, default token_bucket
+//	  limit: 100                # requests per window
+//	  window: 1m
+//	  burst: 20                 # bucket size, default limit
+//	  by: [ip]                  # ip, user and route (its pattern), default ip
+type RateLimitConfig struct {
+	Name      string // prefix of the keys, tell apart limits sharing a store
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+	Burst     int
+	By        []string
+	Key       func(c *Ctx) string // used instead of By when set
+	Store     RateLimitStore      // default a memory store of this limit
+}
+
+// NewRateLimitConfig returns the RateLimitConfig of the rate_limit section
+func NewRateLimitConfig(conf Options) RateLimitConfig {
+	return RateLimitConfig{
+		Name:      conf.GetString("name"),
+		Algorithm: conf.GetString("algorithm", TokenBucket),
+		Limit:     conf.GetInt("limit", 100),
+		Window:    conf.GetDuration("window", time.Minute),
+		Burst:     conf.GetInt("burst"),
+		By:        conf.GetStrings("by", []string{"ip"}),
+	}
+}
+
+// RateLimitRule the rule a store applies to a key
+type RateLimitRule struct {
+	Algorithm string
+	Limit     int
+	Window    time.Duration
+	Burst     int
+}
+
+// RateLimitResult the outcome of a request
+type RateLimitResult struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // until the limit is fully available again
+	RetryAfter time.Duration // until the next request is allowed, when denied
+}
+
+// RateLimitStore keep the state of the limited keys, the ones shared by
+// instances implement the algorithms atomically, as a script would on redis
+type RateLimitStore interface {
+	Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error)
+}
+
+// RateLimit middleware limit the requests of a key, by default the client
+// ip, answering 429 with Retry-After once exceeded. The RateLimit-Limit,
+// RateLimit-Remaining and RateLimit-Reset headers tell the client its
+// quota. Without conf it is configured by the rate_limit section.
+//
+//	app.Use(core.RateLimit())
+//	app.Post("/login", core.RateLimit(core.RateLimitConfig{Limit: 5, Window: time.Minute, By: []string{"ip", "route"}}), login)
+func RateLimit(conf ...RateLimitConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  RateLimitConfig
+		rule RateLimitRule
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewRateLimitConfig(c.Core().Conf.GetMap("rate_limit"))
+			}
+			if cfg.Algorithm == "" {
+				cfg.Algorithm = TokenBucket
+			}
+			if cfg.Limit <= 0 {
+				cfg.Limit = 100
+			}
+			if cfg.Window <= 0 {
+				cfg.Window = time.Minute
+			}
+			if cfg.Key == nil {
+				cfg.Key = rateLimitKey(cfg.By)
+			}
+			if cfg.Store == nil {
+				cfg.Store = NewMemoryRateLimitStore()
+			}
+			rule = RateLimitRule{Algorithm: cfg.Algorithm, Limit: cfg.Limit, Window: cfg.Window, Burst: cfg.Burst}
+		})
+		res, err := cfg.Store.Take(cfg.Name+"|"+cfg.Key(c), rule, time.Now())
+		if err != nil { // a failing store does not take the site down
+			Warn("rate limit: %s", err)
+			return
+		}
+		h := c.W.Header()
+		h.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		h.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		h.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		h.Set("RateLimit-Policy", strconv.Itoa(cfg.Limit)+";w="+strconv.Itoa(ceilSeconds(cfg.Window)))
+		if !res.Allowed {
+			h.Set(HeaderRetryAfter, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			c.Abort().SendStatus(ErrTooManyRequests.Code, ErrTooManyRequests.Message)
+		}
+	}
+}
+
+// rateLimitKey returns the key function of by: the client ip, the id of
+// the principal, its ip when anonymous, and the pattern of the route so
+// /users/1 and /users/2 share a limit
+func rateLimitKey(by []string) func(c *Ctx) string {
+	if len(by) == 0 {
+		by = []string{"ip"}
+	}
+	return func(c *Ctx) string {
+		parts := make([]string, 0, len(by))
+		for _, b := range by {
+			switch b {
+			case "user":
+				if p := c.Principal(); p != nil {
+					parts = append(parts, "user:"+p.ID)
+				} else {
+					parts = append(parts, "ip:"+c.RemoteIP().String())
+				}
+			case "route":
+				parts = append(parts, c.Method()+" "+c.Route())
+			default:
+				parts = append(parts, "ip:"+c.RemoteIP().String())
+			}
+		}
+		return strings.Join(parts, "|")
+	}
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+const rateLimitShards = 32
+
+// MemoryRateLimitStore a RateLimitStore of this process, the keys are
+// spread over shards so requests of different clients seldom contend
+type MemoryRateLimitStore struct {
+	shards [rateLimitShards]rateLimitShard
+}
+
+type rateLimitShard struct {
+	mu    sync.Mutex
+	state map[string]*rateState
+	swept time.Time
+}
+
+// rateState the bucket or the windows of a key
+type rateState struct {
+	tokens  float64   // token bucket
+	last    time.Time // token bucket refill, window start
+	prev    int       // sliding window
+	cur     int
+	expires time.Time
+}
+
+// NewMemoryRateLimitStore returns an empty MemoryRateLimitStore
+func NewMemoryRateLimitStore() *MemoryRateLimitStore {
+	s := &MemoryRateLimitStore{}
+	for i := range s.shards {
+		s.shards[i].state = map[string]*rateState{}
+	}
+	return s
+}
+
+func (s *MemoryRateLimitStore) Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error) {
+	h := uint32(2166136261) // fnv-1a
+	for i := 0; i < len(key); i++ {
+		h = (h ^ uint32(key[i])) * 16777619
+	}
+	shard := &s.shards[h%rateLimitShards]
+	shard.mu.Lock()
+	defer shard.mu.Unlock()
+	if now.Sub(shard.swept) > time.Minute {
+		for k, st := range shard.state {
+			if now.After(st.expires) {
+				delete(shard.state, k)
+			}
+		}
+		shard.swept = now
+	}
+	st, ok := shard.state[key]
+	if !ok {
+		st = &rateState{}
+		shard.state[key] = st
+	}
+	if rule.Algorithm == SlidingWindow {
+		return st.slidingWindow(rule, now), nil
+	}
+	return st.tokenBucket(rule, now), nil
+}
+
+func (st *rateState) tokenBucket(rule RateLimitRule, now time.Time) RateLimitResult {
+	size := float64(rule.Burst)
+	if size <= 0 {
+		size = float64(rule.Limit)
+	}
+	rate := float64(rule.Limit) / rule.Window.Seconds() // tokens per second
+	if st.last.IsZero() {
+		st.tokens = size
+	} else {
+		st.tokens = math.Min(size, st.tokens+now.Sub(st.last).Seconds()*rate)
+	}
+	st.last = now
+	res := RateLimitResult{Limit: int(size)}
+	if st.tokens >= 1 {
+		st.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = time.Duration((1 - st.tokens) / rate * float64(time.Second))
+	}
+	res.Remaining = int(st.tokens)
+	res.Reset = time.Duration((size - st.tokens) / rate * float64(time.Second))
+	st.expires = now.Add(res.Reset)
+	return res
+}
+
+// slidingWindow count the requests of the current window plus the ones of
+// the previous window weighted by how much of it still overlaps
+func (st *rateState) slidingWindow(rule RateLimitRule, now time.Time) RateLimitResult {
+	start := now.Truncate(rule.Window)
+	switch {
+	case start.Equal(st.last):
+	case start.Sub(st.last) == rule.Window:
+		st.prev, st.cur = st.cur, 0
+	default:
+		st.prev, st.cur = 0, 0
+	}
+	st.last = start
+	end := start.Add(rule.Window)
+	overlap := float64(end.Sub(now)) / float64(rule.Window)
+	count := float64(st.prev)*overlap + float64(st.cur)
+	res := RateLimitResult{Limit: rule.Limit, Reset: end.Sub(now)}
+	if count+1 <= float64(rule.Limit) {
+		st.cur++
+		count++
+		res.Allowed = true
+	} else {
+		// the weight of the previous window drops until a request fits
+		res.RetryAfter = end.Sub(now)
+		if st.prev > 0 && float64(st.cur)+1 <= float64(rule.Limit) {
+			excess := count + 1 - float64(rule.Limit)
+			res.RetryAfter = time.Duration(excess / float64(st.prev) * float64(rule.Window))
+		}
+	}
+	res.Remaining = rule.Limit - int(math.Ceil(count))
+	if res.Remaining < 0 {
+		res.Remaining = 0
+	}
+	st.expires = end.Add(rule.Window)
+	return res
+}
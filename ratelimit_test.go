@@ -0,0 +1,90 @@
+package core
+
+import (
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stubRateStore a stand-in for a shared store, allowing a fixed count per key
+type stubRateStore map[string]int
+
+func (s stubRateStore) Take(key string, rule RateLimitRule, now time.Time) (RateLimitResult, error) {
+	s[key]++
+	return RateLimitResult{Allowed: s[key] <= rule.Limit, Limit: rule.Limit, Remaining: rule.Limit - s[key], RetryAfter: time.Second}, nil
+}
+
+func TestRateLimit(t *testing.T) {
+	store := stubRateStore{}
+	app := New()
+	app.Use(func(c *Ctx) {
+		if id := c.GetHeader("X-User"); id != "" {
+			c.SetPrincipal(&Principal{ID: id})
+		}
+	})
+	app.Get("/ip", RateLimit(RateLimitConfig{Limit: 2, Window: time.Minute}), func(c *Ctx) { c.SendString("ok") })
+	byRoute := RateLimit(RateLimitConfig{Limit: 1, By: []string{"user", "route"}, Store: store})
+	app.Get("/user/:id", byRoute, func(c *Ctx) { c.SendString("ok") })
+	app.Get("/post/:id", byRoute, func(c *Ctx) { c.SendString("ok") })
+	get := func(path, ip, user string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		req.RemoteAddr = ip + ":1234"
+		if user != "" {
+			req.Header.Set("X-User", user)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		return w
+	}
+
+	for i, remaining := range []string{"1", "0"} {
+		if w := get("/ip", "10.0.0.1", ""); w.Code != StatusOK || w.Header().Get("RateLimit-Remaining") != remaining {
+			t.Fatalf("request %d: %d remaining %q", i, w.Code, w.Header().Get("RateLimit-Remaining"))
+		}
+	}
+	w := get("/ip", "10.0.0.1", "")
+	if w.Code != StatusTooManyRequests || w.Header().Get(HeaderRetryAfter) != "30" || w.Header().Get("RateLimit-Policy") != "2;w=60" {
+		t.Fatalf("got %d retry %q", w.Code, w.Header().Get(HeaderRetryAfter))
+	}
+	if w := get("/ip", "10.0.0.2", ""); w.Code != StatusOK {
+		t.Fatalf("other ip got %d", w.Code)
+	}
+
+	get("/user/1", "10.0.0.1", "ann")
+	if w := get("/user/1", "10.0.0.2", "ann"); w.Code != StatusTooManyRequests {
+		t.Fatalf("same user got %d", w.Code)
+	}
+	if w := get("/user/2", "10.0.0.1", "ann"); w.Code != StatusTooManyRequests {
+		t.Fatalf("same route pattern got %d", w.Code)
+	}
+	if w := get("/post/1", "10.0.0.1", "ann"); w.Code != StatusOK {
+		t.Fatalf("other route got %d", w.Code)
+	}
+	if _, ok := store["|user:ann|GET /user/:id"]; !ok {
+		t.Fatalf("unexpected keys %v", store)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	s := NewMemoryRateLimitStore()
+	rule := RateLimitRule{Algorithm: SlidingWindow, Limit: 4, Window: time.Minute}
+	start := time.Now().Truncate(time.Minute)
+	for i := 0; i < 4; i++ {
+		if res, _ := s.Take("k", rule, start.Add(50*time.Second)); !res.Allowed {
+			t.Fatalf("request %d denied", i)
+		}
+	}
+	if res, _ := s.Take("k", rule, start.Add(55*time.Second)); res.Allowed || res.RetryAfter != 5*time.Second {
+		t.Fatalf("over the limit %+v", res)
+	}
+	// a quarter into the next window, 3 of the previous 4 still count
+	if res, _ := s.Take("k", rule, start.Add(75*time.Second)); !res.Allowed || res.Remaining != 0 {
+		t.Fatalf("next window %+v", res)
+	}
+	if res, _ := s.Take("k", rule, start.Add(76*time.Second)); res.Allowed {
+		t.Fatalf("weighted count ignored %+v", res)
+	}
+	if res, _ := s.Take("k", rule, start.Add(200*time.Second)); !res.Allowed || res.Remaining != 3 {
+		t.Fatalf("after idle windows %+v", res)
+	}
+}
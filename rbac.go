@@ -0,0 +1,228 @@
+package core
+
+import (
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Authorizer decide whether a principal holds a permission
+type Authorizer interface {
+	Authorize(p *Principal, perm string) bool
+}
+
+// RBAC an Authorizer granting permissions to roles
+//
+//	rbac:
+//	  roles:                 # permissions of the roles, posts.* and * match many
+//	    admin: ["*"]
+//	    editor: [posts.*]
+//	    viewer: [posts.read]
+//	  inherits:              # roles holding the permissions of others
+//	    editor: [viewer]
+//	  principals:            # roles by principal id, added to their own
+//	    ann: [admin]
+type RBAC struct {
+	mu         sync.RWMutex
+	grants     map[string][]string
+	inherits   map[string][]string
+	principals map[string][]string
+}
+
+// NewRBAC returns the RBAC of the rbac section
+func NewRBAC(conf Options) *RBAC {
+	r := &RBAC{
+		grants:     map[string][]string{},
+		inherits:   map[string][]string{},
+		principals: map[string][]string{},
+	}
+	roles, inherits, principals := conf.GetMap("roles"), conf.GetMap("inherits"), conf.GetMap("principals")
+	for role := range roles {
+		r.Grant(role, roles.GetStrings(role)...)
+	}
+	for role := range inherits {
+		r.Inherit(role, inherits.GetStrings(role)...)
+	}
+	for id := range principals {
+		r.Assign(id, principals.GetStrings(id)...)
+	}
+	return r
+}
+
+// Grant give permissions to a role
+func (r *RBAC) Grant(role string, perms ...string) {
+	r.mu.Lock()
+	r.grants[role] = append(r.grants[role], perms...)
+	r.mu.Unlock()
+}
+
+// Inherit give the permissions of the parents to a role
+func (r *RBAC) Inherit(role string, parents ...string) {
+	r.mu.Lock()
+	r.inherits[role] = append(r.inherits[role], parents...)
+	r.mu.Unlock()
+}
+
+// Assign give roles to a principal by its id
+func (r *RBAC) Assign(id string, roles ...string) {
+	r.mu.Lock()
+	r.principals[id] = append(r.principals[id], roles...)
+	r.mu.Unlock()
+}
+
+// Roles returns the roles of a principal, the inherited ones included
+func (r *RBAC) Roles(p *Principal) []string {
+	if p == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	seen := map[string]bool{}
+	var roles []string
+	var walk func([]string)
+	walk = func(names []string) {
+		for _, name := range names {
+			if !seen[name] {
+				seen[name] = true
+				roles = append(roles, name)
+				walk(r.inherits[name])
+			}
+		}
+	}
+	walk(p.Roles)
+	walk(r.principals[p.ID])
+	return roles
+}
+
+// Authorize returns true when one of the roles of p grants perm
+func (r *RBAC) Authorize(p *Principal, perm string) bool {
+	roles := r.Roles(p)
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, role := range roles {
+		for _, grant := range r.grants[role] {
+			if matchPermission(grant, perm) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchPermission match a permission against a grant, "*" matches all of
+// them and "posts.*" the ones below posts
+func matchPermission(grant, perm string) bool {
+	switch {
+	case grant == "*" || grant == perm:
+		return true
+	case strings.HasSuffix(grant, ".*"):
+		return strings.HasPrefix(perm, grant[:len(grant)-1])
+	}
+	return false
+}
+
+// Requirement the permissions a route requires, all of them are needed
+//
+//	app.Post("/posts", core.Require("posts.write"), createPost)
+//	app.Use("/admin", core.Require("admin.access"))
+type Requirement []string
+
+// Require returns the Requirement of perms
+func Require(perms ...string) Requirement {
+	return Requirement(perms)
+}
+
+// check answer 401 without a principal and 403 when one of the permissions
+// is not granted
+func (req Requirement) check(c *Ctx) {
+	p := c.Principal()
+	if p == nil {
+		c.Abort().SendStatus(ErrUnauthorized.Code, ErrUnauthorized.Message)
+		return
+	}
+	for _, perm := range req {
+		if !c.Can(perm) {
+			c.Abort().SendStatus(ErrForbidden.Code, ErrForbidden.Message)
+			return
+		}
+	}
+}
+
+// Can returns true when the principal of the request holds perm
+func (c *Ctx) Can(perm string) bool {
+	a := c.Core().Authorizer
+	return a != nil && a.Authorize(c.Principal(), perm)
+}
+
+// permissionHandler a Handler declaring the permissions of its methods by
+// method name, the ones of "*" apply to all of them
+//
+//	func (h *Posts) Permissions() map[string][]string {
+//		return map[string][]string{"*": {"posts.read"}, "PostCreate": {"posts.write"}}
+//	}
+type permissionHandler interface {
+	Permissions() map[string][]string
+}
+
+// RoutePermission the permissions required by a route
+type RoutePermission struct {
+	Method      string   `json:"method"` // USE for the ones of a group
+	Path        string   `json:"path"`
+	Handler     string   `json:"handler,omitempty"` // Handler method, e.g. app.Posts.PostCreate
+	Permissions []string `json:"permissions"`
+}
+
+// Permissions returns the routes requiring permissions sorted by path
+func (c *Core) Permissions() []RoutePermission {
+	c.permMu.Lock()
+	defer c.permMu.Unlock()
+	list := make([]RoutePermission, len(c.permissions))
+	copy(list, c.permissions)
+	sort.SliceStable(list, func(i, j int) bool {
+		if list[i].Path != list[j].Path {
+			return list[i].Path < list[j].Path
+		}
+		return list[i].Method < list[j].Method
+	})
+	return list
+}
+
+func (c *Core) addPermission(methods []string, path, name string, req Requirement) {
+	c.permMu.Lock()
+	defer c.permMu.Unlock()
+	for _, method := range methods {
+		c.permissions = append(c.permissions, RoutePermission{
+			Method:      method,
+			Path:        path,
+			Handler:     name,
+			Permissions: append([]string(nil), req...),
+		})
+	}
+}
+
+// requirements record the Requirements among handler and replace them by
+// their checks
+func (c *Core) requirements(methods []string, path string, handler interface{}) interface{} {
+	switch h := handler.(type) {
+	case Requirement:
+		c.addPermission(methods, path, "", h)
+		return HandlerFunc(h.check)
+	case []interface{}:
+		out := make([]interface{}, len(h))
+		for i, v := range h {
+			out[i] = c.requirements(methods, path, v)
+		}
+		return out
+	}
+	return handler
+}
+
+// handlerRequirement returns the Requirement of a method of h
+func handlerRequirement(h handler, method string) Requirement {
+	ph, ok := h.(permissionHandler)
+	if !ok {
+		return nil
+	}
+	perms := ph.Permissions()
+	return append(append(Requirement(nil), perms["*"]...), perms[method]...)
+}
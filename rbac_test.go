@@ -0,0 +1,78 @@
+package core
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type rbacTestHandler struct {
+	Handler
+}
+
+func (h *rbacTestHandler) Permissions() map[string][]string {
+	return map[string][]string{"*": {"posts.read"}, "PostPosts": {"posts.write"}}
+}
+
+func (h *rbacTestHandler) GetPosts(c *Ctx) { c.SendString("list") }
+
+func (h *rbacTestHandler) PostPosts(c *Ctx) { c.SendString("created") }
+
+func TestRBAC(t *testing.T) {
+	app := New(Options{"rbac": map[string]interface{}{
+		"roles": map[string]interface{}{
+			"admin":  []interface{}{"*"},
+			"editor": []interface{}{"posts.*"},
+			"viewer": []interface{}{"posts.read"},
+		},
+		"inherits":   map[string]interface{}{"editor": []interface{}{"viewer"}},
+		"principals": map[string]interface{}{"root": []interface{}{"admin"}},
+	}})
+	// a stand-in for the auth middlewares, X-User is id:role,role
+	app.Use(func(c *Ctx) {
+		if user := c.GetHeader("X-User"); user != "" {
+			id, roles, _ := strings.Cut(user, ":")
+			c.SetPrincipal(&Principal{ID: id, Roles: strings.Split(roles, ",")})
+		}
+	})
+	h := &rbacTestHandler{}
+	h.Prefix("/api")
+	app.Use(h)
+	app.Use("/admin", Require("admin.access"))
+	app.Get("/admin/stats", func(c *Ctx) { c.SendString("stats") })
+	app.Delete("/api/posts/:id", Require("posts.delete"), func(c *Ctx) { c.SendString("deleted") })
+
+	for _, tc := range []struct {
+		method, path, user string
+		code               int
+	}{
+		{"GET", "/api/posts", "", StatusUnauthorized},
+		{"GET", "/api/posts", "ann:viewer", StatusOK},
+		{"POST", "/api/posts", "ann:viewer", StatusForbidden},
+		{"POST", "/api/posts", "bob:editor", StatusOK},
+		{"DELETE", "/api/posts/1", "bob:editor", StatusOK},
+		{"GET", "/admin/stats", "bob:editor", StatusForbidden},
+		{"GET", "/admin/stats", "root:", StatusOK},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, nil)
+		if tc.user != "" {
+			req.Header.Set("X-User", tc.user)
+		}
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Code != tc.code {
+			t.Fatalf("%s %s as %q: got %d want %d", tc.method, tc.path, tc.user, w.Code, tc.code)
+		}
+	}
+
+	want := []RoutePermission{
+		{Method: MethodUse, Path: "/admin", Permissions: []string{"admin.access"}},
+		{Method: MethodGet, Path: "/api/posts", Handler: h.HandName() + ".GetPosts", Permissions: []string{"posts.read"}},
+		{Method: MethodPost, Path: "/api/posts", Handler: h.HandName() + ".PostPosts", Permissions: []string{"posts.read", "posts.write"}},
+		{Method: MethodDelete, Path: "/api/posts/:id", Permissions: []string{"posts.delete"}},
+	}
+	if got := app.Permissions(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("permissions\n got %+v\nwant %+v", got, want)
+	}
+}
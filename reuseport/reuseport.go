@@ -16,8 +16,18 @@ import (
 	"github.com/xs23933/core/tcplisten"
 )
 
+// Listen returns a TCP listener with SO_REUSEPORT, TCP_DEFER_ACCEPT and
+// TCP_FASTOPEN enabled.
 func Listen(network, addr string) (net.Listener, error) {
-	ln, err := cfg.NewListener(network, addr)
+	return ListenConfig(cfg, network, addr)
+}
+
+// ListenConfig returns a TCP listener with the options of c, SO_REUSEPORT is
+// always enabled.
+func ListenConfig(c *tcplisten.Config, network, addr string) (net.Listener, error) {
+	rc := *c
+	rc.ReusePort = true
+	ln, err := rc.NewListener(network, addr)
 
 	if err != nil && strings.Contains(err.Error(), "SO_REUSEPORT") {
 		return nil, &ErrNoReusePort{err}
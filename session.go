@@ -0,0 +1,503 @@
+package core
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	sessionFlashPrefix = "_flash."
+	// sessionTouchEvery how often an unchanged session is saved to renew it
+	sessionTouchEvery = time.Minute
+)
+
+var ErrSessionKeys = errors.New("session: the cookie store needs keys")
+
+// SessionData the stored state of a session
+type SessionData struct {
+	ID      string    `json:"id"`
+	Values  Map       `json:"values"`
+	Created time.Time `json:"created"`
+	Touched time.Time `json:"touched"`
+}
+
+// SessionStore keeps the sessions, the cookie holds the value Save returns
+type SessionStore interface {
+	// Load returns the session of a cookie value, nil when there is none
+	Load(value string) (*SessionData, error)
+	// Save stores the session and returns the cookie value
+	Save(data *SessionData, ttl time.Duration) (string, error)
+	// Delete removes the session of a cookie value
+	Delete(value string) error
+}
+
+// SessionConfig options of the Session middleware
+//
+//	session:
+//	  store: memory             # memory, cookie or db, default memory
+//	  name: session             # cookie name
+//	  idle_timeout: 30m         # default 30m
+//	  absolute_timeout: 24h     # default 24h, 0 disables
+//	  domain: example.com       # default the domain key
+//	  path: /
+//	  secure: true              # default true over https
+//	  same_site: lax            # lax, strict or none
+//
+// The cookie store keeps the session encrypted in the cookie with the keys
+// of the keys section, the db store keeps it in the sessions table of Conn().
+type SessionConfig struct {
+	Store           SessionStore
+	Name            string
+	IdleTimeout     time.Duration
+	AbsoluteTimeout time.Duration
+	Domain          string
+	Path            string
+	Secure          *bool
+	SameSite        http.SameSite
+}
+
+// NewSessionConfig returns the SessionConfig of the session section
+func NewSessionConfig(c *Core, conf Options) (SessionConfig, error) {
+	cfg := SessionConfig{
+		Name:            conf.GetString("name", "session"),
+		IdleTimeout:     conf.GetDuration("idle_timeout", 30*time.Minute),
+		AbsoluteTimeout: conf.GetDuration("absolute_timeout", 24*time.Hour),
+		Domain:          conf.GetString("domain", c.Conf.GetString("domain")),
+		Path:            conf.GetString("path", "/"),
+		SameSite:        parseSameSite(conf.GetString("same_site", "lax")),
+	}
+	if _, ok := conf["secure"]; ok {
+		secure := conf.GetBool("secure")
+		cfg.Secure = &secure
+	}
+	switch conf.GetString("store", "memory") {
+	case "cookie":
+		if c.Keys == nil {
+			return cfg, ErrSessionKeys
+		}
+		cfg.Store = NewCookieSessionStore(c.Keys, cfg.Name)
+	case "db":
+		store, err := NewDBSessionStore()
+		if err != nil {
+			return cfg, err
+		}
+		cfg.Store = store
+	default:
+		cfg.Store = NewMemorySessionStore()
+	}
+	return cfg, nil
+}
+
+func parseSameSite(s string) http.SameSite {
+	switch strings.ToLower(s) {
+	case "strict":
+		return http.SameSiteStrictMode
+	case "none":
+		return http.SameSiteNoneMode
+	case "lax":
+		return http.SameSiteLaxMode
+	}
+	return http.SameSiteDefaultMode
+}
+
+// SessionState the session of a request, see Ctx.Session
+type SessionState struct {
+	data     *SessionData
+	old      string // cookie value of the loaded session
+	changed  bool
+	fresh    bool
+	destroy  bool
+	regen    bool
+	cfg      *SessionConfig
+	mu       sync.Mutex
+	saveOnce sync.Once
+}
+
+// ID returns the session id
+func (s *SessionState) ID() string {
+	return s.data.ID
+}
+
+// Get returns the value of key
+func (s *SessionState) Get(key string) interface{} {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.data.Values[key]
+}
+
+// Set set the value of key
+func (s *SessionState) Set(key string, value interface{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values[key] = value
+	s.changed = true
+}
+
+// Delete remove key
+func (s *SessionState) Delete(key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.data.Values[key]; ok {
+		delete(s.data.Values, key)
+		s.changed = true
+	}
+}
+
+// Flash with a value keeps it for the next request, without one returns
+// the value kept by a previous request and removes it.
+//
+//	c.Session().Flash("notice", "saved")
+//	notice := c.Session().Flash("notice")
+func (s *SessionState) Flash(key string, value ...interface{}) interface{} {
+	key = sessionFlashPrefix + key
+	if len(value) > 0 {
+		s.Set(key, value[0])
+		return value[0]
+	}
+	v := s.Get(key)
+	s.Delete(key)
+	return v
+}
+
+// Regenerate give the session a new id keeping its values, call it when
+// the privileges change e.g on login to prevent session fixation.
+func (s *SessionState) Regenerate() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.ID = newSessionID()
+	s.data.Created = time.Now()
+	s.regen, s.changed = true, true
+}
+
+// Destroy remove the session from the store and the client
+func (s *SessionState) Destroy() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data.Values = make(Map)
+	s.destroy = true
+}
+
+func newSessionID() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func newSessionData() *SessionData {
+	now := time.Now()
+	return &SessionData{ID: newSessionID(), Values: make(Map), Created: now, Touched: now}
+}
+
+// expired reports whether data passed the idle or absolute timeout
+func (cfg *SessionConfig) expired(data *SessionData, now time.Time) bool {
+	return (cfg.IdleTimeout > 0 && now.Sub(data.Touched) > cfg.IdleTimeout) ||
+		(cfg.AbsoluteTimeout > 0 && now.Sub(data.Created) > cfg.AbsoluteTimeout)
+}
+
+// expires returns when data expires, zero when it does not
+func (cfg *SessionConfig) expires(data *SessionData) time.Time {
+	var exp time.Time
+	if cfg.IdleTimeout > 0 {
+		exp = data.Touched.Add(cfg.IdleTimeout)
+	}
+	if cfg.AbsoluteTimeout > 0 {
+		if abs := data.Created.Add(cfg.AbsoluteTimeout); exp.IsZero() || abs.Before(exp) {
+			exp = abs
+		}
+	}
+	return exp
+}
+
+// Session middleware load the session of the request and save it before
+// the response is written. Without conf it is configured by the session
+// section.
+//
+//	app.Use(core.Session())
+//	app.Post("/login", func(c *core.Ctx) {
+//		c.Session().Regenerate()
+//		c.Session().Set("user", id)
+//	})
+func Session(conf ...SessionConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  SessionConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+				if cfg.Store == nil {
+					cfg.Store = NewMemorySessionStore()
+				}
+				if cfg.Name == "" {
+					cfg.Name = "session"
+				}
+				if cfg.Path == "" {
+					cfg.Path = "/"
+				}
+				if cfg.Domain == "" {
+					cfg.Domain = c.Core().Conf.GetString("domain")
+				}
+				return
+			}
+			var err error
+			if cfg, err = NewSessionConfig(c.Core(), c.Core().Conf.GetMap("session")); err != nil {
+				panic(err)
+			}
+		})
+		s := &SessionState{cfg: &cfg}
+		if ck, err := c.R.Cookie(cfg.Name); err == nil && ck.Value != "" {
+			s.old = ck.Value
+			data, err := cfg.Store.Load(ck.Value)
+			if err != nil {
+				Warn("session: %s", err)
+			}
+			if data != nil && !cfg.expired(data, time.Now()) {
+				if data.Values == nil {
+					data.Values = make(Map)
+				}
+				s.data = data
+			}
+		}
+		if s.data == nil {
+			s.data = newSessionData()
+			s.fresh = true
+		}
+		c.session = s
+		c.wm.beforeWriteHeader(func() { s.save(c) })
+		c.Next()
+		s.save(c)
+	}
+}
+
+// save store the session once and set its cookie
+func (s *SessionState) save(c *Ctx) {
+	s.saveOnce.Do(func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		cfg := s.cfg
+		ck := &http.Cookie{
+			Name:     cfg.Name,
+			Path:     cfg.Path,
+			Domain:   cfg.Domain,
+			HttpOnly: true,
+			SameSite: cfg.SameSite,
+		}
+		if cfg.Secure != nil {
+			ck.Secure = *cfg.Secure
+		} else {
+			ck.Secure = c.Scheme() == "https"
+		}
+		if s.destroy || (s.regen && s.old != "") {
+			if s.old != "" {
+				if err := cfg.Store.Delete(s.old); err != nil {
+					Warn("session: %s", err)
+				}
+			}
+		}
+		if s.destroy {
+			if s.old != "" {
+				ck.MaxAge = -1
+				http.SetCookie(c.W, ck)
+			}
+			return
+		}
+		now := time.Now()
+		if s.fresh && !s.changed {
+			// nothing to keep for a visitor without a session
+			return
+		}
+		if !s.changed && now.Sub(s.data.Touched) < sessionTouchEvery {
+			return
+		}
+		s.data.Touched = now
+		value, err := cfg.Store.Save(s.data, cfg.expires(s.data).Sub(now))
+		if err != nil {
+			Erro("session: %s", err)
+			return
+		}
+		ck.Value = value
+		ck.Expires = cfg.expires(s.data)
+		http.SetCookie(c.W, ck)
+	})
+}
+
+// Session returns the session of the request, nil without the Session middleware
+func (c *Ctx) Session() *SessionState {
+	return c.session
+}
+
+// MemorySessionStore keeps the sessions in memory, they are lost on restart
+// and not shared between prefork workers. They are kept as JSON like in the
+// other stores, an int set in a session is read back as float64.
+type MemorySessionStore struct {
+	mu       sync.Mutex
+	sessions map[string]memorySession
+	sweep    time.Time
+}
+
+type memorySession struct {
+	raw     []byte
+	expires time.Time
+}
+
+// NewMemorySessionStore returns an empty MemorySessionStore
+func NewMemorySessionStore() *MemorySessionStore {
+	return &MemorySessionStore{sessions: make(map[string]memorySession)}
+}
+
+func (m *MemorySessionStore) Load(id string) (*SessionData, error) {
+	m.mu.Lock()
+	ms, ok := m.sessions[id]
+	m.mu.Unlock()
+	if !ok || (!ms.expires.IsZero() && time.Now().After(ms.expires)) {
+		return nil, nil
+	}
+	data := new(SessionData)
+	return data, sonic.Unmarshal(ms.raw, data)
+}
+
+func (m *MemorySessionStore) Save(data *SessionData, ttl time.Duration) (string, error) {
+	// stored encoded so requests sharing a session do not share its maps
+	raw, err := sonic.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	now := time.Now()
+	ms := memorySession{raw: raw}
+	if ttl > 0 {
+		ms.expires = now.Add(ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.sessions[data.ID] = ms
+	if now.Sub(m.sweep) > time.Minute {
+		m.sweep = now
+		for id, s := range m.sessions {
+			if !s.expires.IsZero() && now.After(s.expires) {
+				delete(m.sessions, id)
+			}
+		}
+	}
+	return data.ID, nil
+}
+
+func (m *MemorySessionStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.sessions, id)
+	return nil
+}
+
+// CookieSessionStore keeps the sessions encrypted in the cookie, nothing is
+// stored on the server. Browsers limit cookies to about 4KB.
+type CookieSessionStore struct {
+	keys *Keyring
+	name string
+}
+
+// NewCookieSessionStore returns a CookieSessionStore encrypting with keys,
+// name is the cookie name
+func NewCookieSessionStore(keys *Keyring, name string) *CookieSessionStore {
+	return &CookieSessionStore{keys: keys, name: name}
+}
+
+func (s *CookieSessionStore) Load(value string) (*SessionData, error) {
+	raw, err := s.keys.Decrypt(s.name, value)
+	if err != nil {
+		// a cookie of a retired key starts a new session
+		return nil, nil
+	}
+	data := new(SessionData)
+	return data, sonic.Unmarshal(raw, data)
+}
+
+func (s *CookieSessionStore) Save(data *SessionData, ttl time.Duration) (string, error) {
+	raw, err := sonic.Marshal(data)
+	if err != nil {
+		return "", err
+	}
+	return s.keys.Encrypt(s.name, raw)
+}
+
+func (s *CookieSessionStore) Delete(value string) error {
+	return nil
+}
+
+// SessionRecord a session of the DBSessionStore
+type SessionRecord struct {
+	ID      string `gorm:"primaryKey;size:64"`
+	Data    Map
+	Created time.Time
+	Touched time.Time
+	Expires *time.Time `gorm:"index"`
+}
+
+func (SessionRecord) TableName() string {
+	return "sessions"
+}
+
+// DBSessionStore keeps the sessions in the sessions table. Values come back
+// decoded from JSON, numbers as float64.
+type DBSessionStore struct {
+	db *DB
+}
+
+// NewDBSessionStore returns a DBSessionStore of db, default Conn(), and
+// migrates the sessions table
+func NewDBSessionStore(db ...*DB) (*DBSessionStore, error) {
+	var tx *DB
+	if len(db) > 0 {
+		tx = db[0]
+	} else {
+		tx = Conn()
+	}
+	if tx == nil {
+		return nil, ErrNoConfig
+	}
+	if err := tx.AutoMigrate(&SessionRecord{}); err != nil {
+		return nil, err
+	}
+	return &DBSessionStore{db: tx}, nil
+}
+
+func (s *DBSessionStore) Load(id string) (*SessionData, error) {
+	var rec SessionRecord
+	res := s.db.Where("id = ?", id).Limit(1).Find(&rec)
+	if res.Error != nil || res.RowsAffected == 0 {
+		return nil, res.Error
+	}
+	if rec.Expires != nil && time.Now().After(*rec.Expires) {
+		return nil, nil
+	}
+	return &SessionData{ID: rec.ID, Values: rec.Data, Created: rec.Created, Touched: rec.Touched}, nil
+}
+
+func (s *DBSessionStore) Save(data *SessionData, ttl time.Duration) (string, error) {
+	rec := SessionRecord{ID: data.ID, Data: data.Values, Created: data.Created, Touched: data.Touched}
+	if ttl > 0 {
+		exp := time.Now().Add(ttl)
+		rec.Expires = &exp
+	}
+	err := s.db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&rec).Error
+	return data.ID, err
+}
+
+func (s *DBSessionStore) Delete(id string) error {
+	return s.db.Where("id = ?", id).Delete(&SessionRecord{}).Error
+}
+
+// Cleanup delete the expired sessions, run it periodically
+func (s *DBSessionStore) Cleanup() error {
+	return s.db.Where("expires < ?", time.Now()).Delete(&SessionRecord{}).Error
+}
@@ -0,0 +1,118 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func sessionApp(conf SessionConfig) *Core {
+	app := New()
+	app.Use(Session(conf))
+	app.Get("/set", func(c *Ctx) {
+		c.Session().Set("user", "ann")
+		c.Session().Flash("notice", "saved")
+		c.SendString("ok")
+	})
+	app.Get("/get", func(c *Ctx) {
+		notice, _ := c.Session().Flash("notice").(string)
+		user, _ := c.Session().Get("user").(string)
+		c.SendString(user + "|" + notice)
+	})
+	app.Get("/login", func(c *Ctx) {
+		c.Session().Regenerate()
+		c.SendString("ok")
+	})
+	app.Get("/logout", func(c *Ctx) {
+		c.Session().Destroy()
+		c.SendString("ok")
+	})
+	return app
+}
+
+func sessionGet(app *Core, path string, ck *http.Cookie) (string, *http.Cookie) {
+	req := httptest.NewRequest("GET", path, nil)
+	if ck != nil {
+		req.AddCookie(ck)
+	}
+	w := httptest.NewRecorder()
+	app.ServeHTTP(w, req)
+	var out *http.Cookie
+	for _, c := range w.Result().Cookies() {
+		out = c
+	}
+	return w.Body.String(), out
+}
+
+func TestSessionStores(t *testing.T) {
+	old, _ := NewKeyring("old")
+	rotated, _ := NewKeyring("new", "old")
+	for name, conf := range map[string]SessionConfig{
+		"memory": {Store: NewMemorySessionStore(), IdleTimeout: time.Hour},
+		"cookie": {Store: NewCookieSessionStore(old, "session"), IdleTimeout: time.Hour},
+	} {
+		app := sessionApp(conf)
+		if _, ck := sessionGet(app, "/get", nil); ck != nil {
+			t.Fatalf("%s: cookie set for an unchanged session", name)
+		}
+		_, ck := sessionGet(app, "/set", nil)
+		if ck == nil || !ck.HttpOnly {
+			t.Fatalf("%s: no session cookie %v", name, ck)
+		}
+		if body, next := sessionGet(app, "/get", ck); body != "ann|saved" {
+			t.Fatalf("%s: got %q", name, body)
+		} else {
+			ck = next
+		}
+		if body, _ := sessionGet(app, "/get", ck); body != "ann|" {
+			t.Fatalf("%s: flash kept %q", name, body)
+		}
+
+		_, regen := sessionGet(app, "/login", ck)
+		if regen == nil || regen.Value == ck.Value {
+			t.Fatalf("%s: session not regenerated", name)
+		}
+		if body, _ := sessionGet(app, "/get", regen); body != "ann|" {
+			t.Fatalf("%s: regenerated session lost values %q", name, body)
+		}
+		if name == "memory" {
+			if body, _ := sessionGet(app, "/get", ck); body != "|" {
+				t.Fatalf("%s: old session id still valid %q", name, body)
+			}
+		}
+		if _, out := sessionGet(app, "/logout", regen); out == nil || out.MaxAge >= 0 {
+			t.Fatalf("%s: cookie not removed %v", name, out)
+		}
+	}
+
+	// a cookie of an old key is still read after a rotation
+	_, ck := sessionGet(sessionApp(SessionConfig{Store: NewCookieSessionStore(old, "session")}), "/set", nil)
+	app := sessionApp(SessionConfig{Store: NewCookieSessionStore(rotated, "session")})
+	if body, _ := sessionGet(app, "/get", ck); body != "ann|saved" {
+		t.Fatalf("rotated key got %q", body)
+	}
+}
+
+func TestSessionTimeout(t *testing.T) {
+	store := NewMemorySessionStore()
+	app := sessionApp(SessionConfig{Store: store, IdleTimeout: time.Hour})
+	_, ck := sessionGet(app, "/set", nil)
+	data, _ := store.Load(ck.Value)
+	data.Touched = time.Now().Add(-2 * time.Hour)
+	store.Save(data, 0)
+	if body, _ := sessionGet(app, "/get", ck); body != "|" {
+		t.Fatalf("idle session still valid %q", body)
+	}
+}
+
+func TestSessionDomain(t *testing.T) {
+	app := New(Options{"domain": "example.com"})
+	app.Use(Session(SessionConfig{}))
+	app.Get("/set", func(c *Ctx) {
+		c.Session().Set("user", "ann")
+	})
+	if _, ck := sessionGet(app, "/set", nil); ck == nil || ck.Domain != "example.com" {
+		t.Fatalf("got cookie %v", ck)
+	}
+}
@@ -0,0 +1,171 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ShutdownHook run by Shutdown after the requests are drained
+type ShutdownHook func(ctx context.Context) error
+
+type shutdownHook struct {
+	name string
+	fn   ShutdownHook
+}
+
+// lifecycle track the server state for a graceful shutdown
+type lifecycle struct {
+	mu       sync.Mutex
+	hooks    []shutdownHook
+	draining int32
+	inflight int64
+	once     sync.Once
+	done     chan struct{}
+	err      error
+}
+
+// ShutdownError reports what did not finish in time during Shutdown
+type ShutdownError struct {
+	Requests int64    // requests still in flight after the drain timeout
+	Hooks    []string // hooks not finished before the hook timeout
+	Errs     []error  // errors returned by hooks
+}
+
+func (e *ShutdownError) Error() string {
+	parts := make([]string, 0, 3)
+	if e.Requests > 0 {
+		parts = append(parts, fmt.Sprintf("%d requests still in flight", e.Requests))
+	}
+	if len(e.Hooks) > 0 {
+		parts = append(parts, fmt.Sprintf("hooks timed out: %s", strings.Join(e.Hooks, ", ")))
+	}
+	for _, err := range e.Errs {
+		parts = append(parts, err.Error())
+	}
+	return "shutdown: " + strings.Join(parts, "; ")
+}
+
+// OnShutdown register a hook run after the requests are drained, hooks run
+// in reverse order of registration, like defer.
+func (c *Core) OnShutdown(name string, fn ShutdownHook) *Core {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	c.life.hooks = append(c.life.hooks, shutdownHook{name, fn})
+	return c
+}
+
+// Ready reports whether the server accepts traffic, false once Shutdown started
+func (c *Core) Ready() bool {
+	return atomic.LoadInt32(&c.life.draining) == 0
+}
+
+// Readiness handler for load balancer probes, respond 503 once Shutdown started
+//
+//	app.Get("/readyz", app.Readiness)
+func (c *Core) Readiness(ctx *Ctx) {
+	if !c.Ready() {
+		ctx.SendStatus(StatusServiceUnavailable, "shutting down")
+		return
+	}
+	ctx.SendStatus(StatusOK, "ok")
+}
+
+// InFlight returns the number of requests being served
+func (c *Core) InFlight() int64 {
+	return atomic.LoadInt64(&c.life.inflight)
+}
+
+// Shutdown stop the server gracefully, see ShutdownContext
+func (c *Core) Shutdown() error {
+	return c.ShutdownContext(context.Background())
+}
+
+// ShutdownContext stop the server gracefully:
+//
+//  1. readiness flips to failing and waits shutdown_delay so load balancers notice
+//  2. listeners are closed and in-flight requests drained within shutdown_timeout
+//  3. OnShutdown hooks run within shutdown_timeout, hooks left when it expires
+//     are still called but not waited for
+//
+// Calling it again waits for the first call and returns its result.
+//
+//	shutdown_delay: 0s      # default 0
+//	shutdown_timeout: 30s   # default 30s
+func (c *Core) ShutdownContext(ctx context.Context) error {
+	c.life.once.Do(func() {
+		c.life.err = c.shutdown(ctx)
+		close(c.life.done)
+	})
+	<-c.life.done
+	return c.life.err
+}
+
+func (c *Core) shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&c.life.draining, 1)
+	if c.Certs != nil {
+		c.Certs.Stop()
+	}
+	report := &ShutdownError{}
+
+	if delay := c.Conf.GetDuration("shutdown_delay"); delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+		}
+	}
+
+	timeout := c.Conf.GetDuration("shutdown_timeout", 30*time.Second)
+	drainCtx, cancel := context.WithTimeout(ctx, timeout)
+	err := c.Server.Shutdown(drainCtx)
+	cancel()
+	if err != nil {
+		report.Requests = c.InFlight()
+		Warn("shutdown: drain timeout, %d requests still in flight", report.Requests)
+		c.Server.Close()
+	}
+
+	c.life.mu.Lock()
+	hooks := append([]shutdownHook{}, c.life.hooks...)
+	c.life.mu.Unlock()
+	hookCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	for i := len(hooks) - 1; i >= 0; i-- {
+		h := hooks[i]
+		done := make(chan error, 1)
+		go func() {
+			done <- h.fn(hookCtx)
+		}()
+		if hookCtx.Err() != nil {
+			report.Hooks = append(report.Hooks, h.name)
+			continue
+		}
+		select {
+		case err := <-done:
+			if err != nil {
+				report.Errs = append(report.Errs, fmt.Errorf("%s: %w", h.name, err))
+			}
+		case <-hookCtx.Done():
+			report.Hooks = append(report.Hooks, h.name)
+		}
+	}
+	if len(report.Hooks) > 0 {
+		Warn("shutdown: hooks timed out: %s", strings.Join(report.Hooks, ", "))
+	}
+
+	if report.Requests > 0 || len(report.Hooks) > 0 || len(report.Errs) > 0 {
+		return report
+	}
+	return nil
+}
+
+// waitShutdown block until a started Shutdown finished, so Serve does not
+// return while requests are still draining
+func (c *Core) waitShutdown() {
+	if !c.Ready() {
+		<-c.life.done
+	}
+}
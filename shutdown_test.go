@@ -0,0 +1,92 @@
+package core
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrain(t *testing.T) {
+	ln := NewInmemoryListener()
+	app := New(Options{"shutdown_timeout": "50ms"})
+	started, release := make(chan struct{}), make(chan struct{})
+	app.Get("/slow", func(c *Ctx) {
+		close(started)
+		<-release
+		c.SendString("done")
+	})
+	var order []string
+	app.OnShutdown("stuck", func(ctx context.Context) error {
+		<-release
+		return nil
+	})
+	app.OnShutdown("first", func(ctx context.Context) error {
+		order = append(order, "first")
+		return nil
+	})
+	app.OnShutdown("last", func(ctx context.Context) error {
+		order = append(order, "last")
+		return errors.New("boom")
+	})
+	served := make(chan error, 1)
+	go func() { served <- app.Serve(ln) }()
+
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: unused.host\r\n\r\n"))
+	<-started
+
+	err = app.Shutdown()
+	close(release)
+	if app.Ready() {
+		t.Fatalf("expected readiness to fail after shutdown")
+	}
+	var report *ShutdownError
+	if !errors.As(err, &report) {
+		t.Fatalf("expected shutdown report, got %v", err)
+	}
+	if report.Requests != 1 || len(report.Hooks) != 1 || report.Hooks[0] != "stuck" || len(report.Errs) != 1 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if len(order) != 2 || order[0] != "last" || order[1] != "first" {
+		t.Fatalf("unexpected hook order %v", order)
+	}
+	if err = <-served; err != http.ErrServerClosed {
+		t.Fatalf("unexpected serve error %v", err)
+	}
+	if app.Shutdown() != report {
+		t.Fatalf("expected second call to return the first result")
+	}
+}
+
+func TestShutdownGraceful(t *testing.T) {
+	ln := NewInmemoryListener()
+	app := New()
+	started := make(chan struct{})
+	app.Get("/slow", func(c *Ctx) {
+		close(started)
+		time.Sleep(20 * time.Millisecond)
+		c.SendString("done")
+	})
+	app.Get("/readyz", app.Readiness)
+	go app.Serve(ln)
+
+	conn, _ := ln.Dial()
+	conn.Write([]byte("GET /slow HTTP/1.1\r\nHost: unused.host\r\n\r\n"))
+	<-started
+	go func() {
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil || resp.StatusCode != StatusOK {
+			t.Errorf("in-flight request not drained: %v %v", resp, err)
+		}
+		conn.Close()
+	}()
+	if err := app.Shutdown(); err != nil {
+		t.Fatalf("unexpected shutdown error %v", err)
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"net"
 	"os"
 	"syscall"
+	"time"
 )
 
 // Config provides options to enable on the returned listener.
@@ -41,6 +42,16 @@ type Config struct {
 	//
 	// By default system-level backlog value is used.
 	Backlog int
+
+	// UserTimeout sets TCP_USER_TIMEOUT, the time transmitted data may stay
+	// unacknowledged before the connection is closed. Linux only.
+	UserTimeout time.Duration
+
+	// RecvBuffer sets SO_RCVBUF, SendBuffer sets SO_SNDBUF.
+	//
+	// By default system-level values are used.
+	RecvBuffer int
+	SendBuffer int
 }
 
 // NewListener returns TCP listener with options set in the Config.
@@ -48,7 +59,7 @@ type Config struct {
 // The function may be called many times for creating distinct listeners
 // with the given config.
 //
-// Only tcp4 and tcp6 networks are supported.
+// Only tcp, tcp4 and tcp6 networks are supported.
 func (cfg *Config) NewListener(network, addr string) (net.Listener, error) {
 	sa, soType, err := getSockaddr(network, addr)
 	if err != nil {
@@ -94,6 +105,30 @@ func (cfg *Config) fdSetup(fd int, sa syscall.Sockaddr, addr string) error {
 		return fmt.Errorf("cannot disable Nagle's algorithm: %s", err)
 	}
 
+	if err = cfg.setOptions(fd); err != nil {
+		return err
+	}
+
+	if err = syscall.Bind(fd, sa); err != nil {
+		return fmt.Errorf("cannot bind to %q: %s", addr, err)
+	}
+
+	backlog := cfg.Backlog
+	if backlog <= 0 {
+		if backlog, err = soMaxConn(); err != nil {
+			return fmt.Errorf("cannot determine backlog to pass to listen(2): %s", err)
+		}
+	}
+	if err = syscall.Listen(fd, backlog); err != nil {
+		return fmt.Errorf("cannot listen on %q: %s", addr, err)
+	}
+
+	return nil
+}
+
+func (cfg *Config) setOptions(fd int) error {
+	var err error
+
 	if cfg.ReusePort {
 		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, soReusePort, 1); err != nil {
 			return fmt.Errorf("cannot enable SO_REUSEPORT: %s", err)
@@ -112,26 +147,42 @@ func (cfg *Config) fdSetup(fd int, sa syscall.Sockaddr, addr string) error {
 		}
 	}
 
-	if err = syscall.Bind(fd, sa); err != nil {
-		return fmt.Errorf("cannot bind to %q: %s", addr, err)
+	if cfg.UserTimeout > 0 {
+		if err = setUserTimeout(fd, cfg.UserTimeout); err != nil {
+			return err
+		}
 	}
 
-	backlog := cfg.Backlog
-	if backlog <= 0 {
-		if backlog, err = soMaxConn(); err != nil {
-			return fmt.Errorf("cannot determine backlog to pass to listen(2): %s", err)
+	if cfg.RecvBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_RCVBUF, cfg.RecvBuffer); err != nil {
+			return fmt.Errorf("cannot set SO_RCVBUF: %s", err)
 		}
 	}
-	if err = syscall.Listen(fd, backlog); err != nil {
-		return fmt.Errorf("cannot listen on %q: %s", addr, err)
+
+	if cfg.SendBuffer > 0 {
+		if err = syscall.SetsockoptInt(fd, syscall.SOL_SOCKET, syscall.SO_SNDBUF, cfg.SendBuffer); err != nil {
+			return fmt.Errorf("cannot set SO_SNDBUF: %s", err)
+		}
 	}
 
 	return nil
 }
 
+// Control applies the options but Backlog to a socket before bind, for use
+// as net.ListenConfig.Control.
+func (cfg *Config) Control(network, address string, c syscall.RawConn) error {
+	var err error
+	if cerr := c.Control(func(fd uintptr) {
+		err = cfg.setOptions(int(fd))
+	}); cerr != nil {
+		return cerr
+	}
+	return err
+}
+
 func getSockaddr(network, addr string) (sa syscall.Sockaddr, soType int, err error) {
-	if network != "tcp4" && network != "tcp6" {
-		return nil, -1, errors.New("only tcp4 and tcp6 network is supported")
+	if network != "tcp" && network != "tcp4" && network != "tcp6" {
+		return nil, -1, errors.New("only tcp, tcp4 and tcp6 network is supported")
 	}
 
 	tcpAddr, err := net.ResolveTCPAddr(network, addr)
@@ -139,6 +190,14 @@ func getSockaddr(network, addr string) (sa syscall.Sockaddr, soType int, err err
 		return nil, -1, err
 	}
 
+	// tcp listens on IPv4 unless the address is IPv6
+	if network == "tcp" {
+		network = "tcp4"
+		if tcpAddr.IP != nil && tcpAddr.IP.To4() == nil {
+			network = "tcp6"
+		}
+	}
+
 	switch network {
 	case "tcp4":
 		var sa4 syscall.SockaddrInet4
@@ -4,7 +4,9 @@
 package tcplisten
 
 import (
+	"errors"
 	"syscall"
+	"time"
 )
 
 const soReusePort = syscall.SO_REUSEPORT
@@ -19,6 +21,10 @@ func enableFastOpen(fd int) error {
 	return nil
 }
 
+func setUserTimeout(fd int, d time.Duration) error {
+	return errors.New("TCP_USER_TIMEOUT is not supported")
+}
+
 func soMaxConn() (int, error) {
 	// TODO: properly implement it
 	return syscall.SOMAXCONN, nil
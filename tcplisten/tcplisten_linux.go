@@ -10,11 +10,13 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 )
 
 const (
-	soReusePort = 0x0F
-	tcpFastOpen = 0x17
+	soReusePort    = 0x0F
+	tcpFastOpen    = 0x17
+	tcpUserTimeout = 0x12
 )
 
 func enableDeferAccept(fd int) error {
@@ -33,6 +35,13 @@ func enableFastOpen(fd int) error {
 
 const fastOpenQlen = 16 * 1024
 
+func setUserTimeout(fd int, d time.Duration) error {
+	if err := syscall.SetsockoptInt(fd, syscall.IPPROTO_TCP, tcpUserTimeout, int(d/time.Millisecond)); err != nil {
+		return fmt.Errorf("cannot set TCP_USER_TIMEOUT: %s", err)
+	}
+	return nil
+}
+
 func soMaxConn() (int, error) {
 	data, err := ioutil.ReadFile(soMaxConnFilePath)
 	if err != nil {
@@ -258,6 +258,10 @@ func (ve *TextEngine) AddFunc(name string, fn interface{}) Views {
 }
 
 var textHelpers = template.FuncMap{
+	// hidden input of the CSRF token, {{ csrfField . }}
+	"csrfField": func(binding any) string {
+		return string(csrfField(binding))
+	},
 
 	// Format a date according to the application's default date(time) format.
 	"date": func(date time.Time, f ...string) string {
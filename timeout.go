@@ -0,0 +1,242 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+	"time"
+)
+
+// TimeoutConfig options of the Timeout middleware
+//
+//	timeout:
+//	  timeout: 5s
+//	  status: 503   # or 504
+//	  message: Service Unavailable
+type TimeoutConfig struct {
+	Timeout time.Duration
+	Status  int
+	Message string
+}
+
+// NewTimeoutConfig returns the TimeoutConfig of the timeout section
+func NewTimeoutConfig(conf Options) TimeoutConfig {
+	return TimeoutConfig{
+		Timeout: conf.GetDuration("timeout", 5*time.Second),
+		Status:  conf.GetInt("status", StatusServiceUnavailable),
+		Message: conf.GetString("message"),
+	}
+}
+
+// Timeout middleware run the handlers after it with a deadline on
+// c.Context, the ones doing long work watch c.Done(). Their response is
+// buffered and sent once they return, past the deadline the client gets
+// Status with Message instead and what they write later is dropped with
+// http.ErrHandlerTimeout. Streaming and hijacking are not supported under
+// it. Unlike Server.WriteTimeout the connection is kept. Without conf it is
+// configured by the timeout section.
+//
+//	app.Get("/report", core.Timeout(core.TimeoutConfig{Timeout: 2 * time.Second, Status: core.StatusGatewayTimeout}), report)
+func Timeout(conf ...TimeoutConfig) HandlerFunc {
+	var (
+		once sync.Once
+		cfg  TimeoutConfig
+	)
+	return func(c *Ctx) {
+		once.Do(func() {
+			if len(conf) > 0 {
+				cfg = conf[0]
+			} else {
+				cfg = NewTimeoutConfig(c.Core().Conf.GetMap("timeout"))
+			}
+			if cfg.Timeout <= 0 {
+				cfg.Timeout = 5 * time.Second
+			}
+			if cfg.Status == 0 {
+				cfg.Status = StatusServiceUnavailable
+			}
+			if cfg.Message == "" {
+				cfg.Message = StatusMessage(cfg.Status)
+			}
+		})
+		ctx, cancel := context.WithTimeout(c.Context, cfg.Timeout)
+		defer cancel()
+		tw := &timeoutWriter{header: http.Header{}, status: StatusOK}
+		// the handlers run on a ctx of their own, a late one may still use
+		// it once c went back to the pool
+		inner := c.fork(ctx, tw)
+		done := make(chan struct{})
+		panicked := make(chan interface{}, 1)
+		go func() {
+			defer func() {
+				if p := recover(); p != nil {
+					// the stack of this goroutine is lost by the panic of c,
+					// which keeps the value so http.ErrAbortHandler works
+					if p != http.ErrAbortHandler {
+						Erro("timeout: panic: %v\n%s", p, debug.Stack())
+					}
+					panicked <- p
+				}
+			}()
+			inner.Next()
+			close(done)
+		}()
+		select {
+		case p := <-panicked:
+			panic(p)
+		case <-done:
+			c.join(inner)
+			tw.writeTo(c.W)
+		case <-ctx.Done():
+			tw.timeout()
+			if ctx.Err() == context.DeadlineExceeded {
+				c.Abort().SendStatus(cfg.Status, cfg.Message)
+			} else {
+				c.Abort() // the client went away
+			}
+			// the late handlers still read the body
+			c.R = c.R.WithContext(c.Context)
+			c.R.Body = http.NoBody
+		}
+	}
+}
+
+// fork returns a ctx running the rest of the handlers of c with ctx and w
+func (c *Ctx) fork(ctx context.Context, w *timeoutWriter) *Ctx {
+	vars := make(map[string]interface{}, len(c.vars))
+	c.mu.RLock()
+	for k, v := range c.vars {
+		vars[k] = v
+	}
+	c.mu.RUnlock()
+	return &Ctx{
+		Context:  ctx,
+		W:        w,
+		Resp:     w,
+		R:        c.R.WithContext(ctx),
+		core:     c.core,
+		Config:   c.Config,
+		params:   c.params,
+		path:     c.path,
+		route:    c.route,
+		idx:      c.idx,
+		vars:     vars,
+		querys:   c.querys,
+		sameSite: c.sameSite,
+		handlers: c.handlers,
+		theme:    c.theme,
+		session:  c.session,
+	}
+}
+
+// join take the state the handlers of a fork left
+func (c *Ctx) join(inner *Ctx) {
+	c.mu.Lock()
+	c.vars = inner.vars
+	c.mu.Unlock()
+	c.idx = inner.idx
+	c.R.Body = inner.R.Body
+	c.sameSite = inner.sameSite
+	c.theme = inner.theme
+	c.session = inner.session
+}
+
+// timeoutWriter a ResponseWriter buffering the response of the handlers
+// under Timeout, nothing is written after the deadline
+type timeoutWriter struct {
+	mu       sync.Mutex
+	header   http.Header
+	buf      bytes.Buffer
+	status   int
+	written  bool
+	timedOut bool
+}
+
+func (w *timeoutWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *timeoutWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.timedOut {
+		return 0, http.ErrHandlerTimeout
+	}
+	w.written = true
+	return w.buf.Write(p)
+}
+
+func (w *timeoutWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *timeoutWriter) WriteHeader(code int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if code > 0 && !w.written && !w.timedOut {
+		w.status = code
+	}
+}
+
+func (w *timeoutWriter) DoWriteHeader() {
+	w.mu.Lock()
+	w.written = true
+	w.mu.Unlock()
+}
+
+func (w *timeoutWriter) Status() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.status
+}
+
+func (w *timeoutWriter) Size() int {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if !w.written {
+		return -1
+	}
+	return w.buf.Len()
+}
+
+func (w *timeoutWriter) Written() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.written
+}
+
+// Flush the response is sent once the handlers return
+func (w *timeoutWriter) Flush() {}
+
+func (w *timeoutWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	return nil, nil, http.ErrNotSupported
+}
+
+func (w *timeoutWriter) Pusher() http.Pusher {
+	return nil
+}
+
+// timeout drop what the handlers write from now on
+func (w *timeoutWriter) timeout() {
+	w.mu.Lock()
+	w.timedOut = true
+	w.mu.Unlock()
+}
+
+// writeTo send the buffered response
+func (w *timeoutWriter) writeTo(dst ResponseWriter) {
+	h := dst.Header()
+	for k, v := range w.header {
+		h[k] = v
+	}
+	dst.WriteHeader(w.status)
+	if w.buf.Len() > 0 {
+		dst.Write(w.buf.Bytes())
+	} else if w.written {
+		dst.DoWriteHeader()
+	}
+}
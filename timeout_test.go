@@ -0,0 +1,71 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTimeout(t *testing.T) {
+	late := make(chan error, 1)
+	var user interface{}
+	app := New()
+	app.Use(func(c *Ctx) {
+		c.Next()
+		user, _ = c.Get("user")
+	})
+	app.Get("/fast", Timeout(TimeoutConfig{Timeout: time.Second}), func(c *Ctx) {
+		if _, ok := c.Deadline(); !ok {
+			t.Error("no deadline on the ctx")
+		}
+		c.Set("user", "ann")
+		c.SetHeader("X-Fast", "1")
+		c.Status(StatusCreated).SendString("done")
+	})
+	app.Get("/slow", Timeout(TimeoutConfig{Timeout: 20 * time.Millisecond, Status: StatusGatewayTimeout}), func(c *Ctx) {
+		<-c.Done()
+		time.Sleep(10 * time.Millisecond)
+		c.SetHeader("X-Late", "1")
+		_, err := c.W.WriteString("late")
+		late <- err
+	})
+	get := func(path string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, httptest.NewRequest("GET", path, nil))
+		return w
+	}
+
+	w := get("/fast")
+	if w.Code != StatusCreated || w.Body.String() != "done" || w.Header().Get("X-Fast") != "1" || user != "ann" {
+		t.Fatalf("got %d %q %v user %v", w.Code, w.Body.String(), w.Header(), user)
+	}
+	start := time.Now()
+	w = get("/slow")
+	if w.Code != StatusGatewayTimeout || w.Body.String() != StatusMessage(StatusGatewayTimeout) || time.Since(start) > time.Second {
+		t.Fatalf("got %d %q", w.Code, w.Body.String())
+	}
+	if err := <-late; err != http.ErrHandlerTimeout || w.Header().Get("X-Late") != "" || w.Body.String() != StatusMessage(StatusGatewayTimeout) {
+		t.Fatalf("late write got %v %q", err, w.Body.String())
+	}
+}
+
+func TestTimeoutPanic(t *testing.T) {
+	var got interface{}
+	app := New()
+	app.Use(func(c *Ctx) {
+		defer func() {
+			if got = recover(); got != nil {
+				c.Abort()
+			}
+		}()
+		c.Next()
+	})
+	app.Get("/abort", Timeout(TimeoutConfig{Timeout: time.Second}), func(c *Ctx) {
+		panic(http.ErrAbortHandler)
+	})
+	app.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/abort", nil))
+	if got != http.ErrAbortHandler {
+		t.Fatalf("recovered %v", got)
+	}
+}
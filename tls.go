@@ -0,0 +1,171 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var tlsClientAuth = map[string]tls.ClientAuthType{
+	"none":               tls.NoClientCert,
+	"request":            tls.RequestClientCert,
+	"require":            tls.RequireAnyClientCert,
+	"verify_if_given":    tls.VerifyClientCertIfGiven,
+	"require_and_verify": tls.RequireAndVerifyClientCert,
+}
+
+// NewTLSConfig build tls.Config from the tls config section, certificates
+// are served by a CertManager, see NewCertManager.
+//
+//	tls:
+//	  min_version: "1.2"            # 1.0 1.1 1.2 1.3
+//	  max_version: "1.3"
+//	  cipher_suites:                # names from crypto/tls, TLS 1.3 suites are not configurable
+//	    - TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256
+//	  client_auth: require_and_verify # none request require verify_if_given require_and_verify
+//	  client_ca: ./certs/ca.pem
+//	  http2: true                   # negotiate h2 with ALPN. default true
+func NewTLSConfig(conf Options) (*tls.Config, error) {
+	cfg := &tls.Config{
+		MinVersion: tls.VersionTLS12,
+	}
+	if v := conf.ToString("min_version"); v != "" {
+		ver, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown min_version %q", v)
+		}
+		cfg.MinVersion = ver
+	}
+	if v := conf.ToString("max_version"); v != "" {
+		ver, ok := tlsVersions[strings.TrimPrefix(strings.ToLower(v), "tls")]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown max_version %q", v)
+		}
+		cfg.MaxVersion = ver
+	}
+
+	if names := conf.GetStrings("cipher_suites"); len(names) > 0 {
+		suites := make(map[string]uint16)
+		for _, s := range append(tls.CipherSuites(), tls.InsecureCipherSuites()...) {
+			suites[s.Name] = s.ID
+		}
+		for _, name := range names {
+			id, ok := suites[name]
+			if !ok {
+				return nil, fmt.Errorf("tls: unknown cipher suite %q", name)
+			}
+			cfg.CipherSuites = append(cfg.CipherSuites, id)
+		}
+	}
+
+	if v := conf.GetString("client_auth"); v != "" {
+		auth, ok := tlsClientAuth[strings.ToLower(v)]
+		if !ok {
+			return nil, fmt.Errorf("tls: unknown client_auth %q", v)
+		}
+		cfg.ClientAuth = auth
+	}
+	if ca := conf.GetString("client_ca"); ca != "" {
+		pem, err := os.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("tls: client_ca: %w", err)
+		}
+		cfg.ClientCAs = x509.NewCertPool()
+		if !cfg.ClientCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("tls: client_ca: no certificate found in %s", ca)
+		}
+	}
+
+	if conf.GetBool("http2", true) {
+		cfg.NextProtos = []string{http2.NextProtoTLS, "http/1.1"}
+	}
+	return cfg, nil
+}
+
+// loadCertificate load a key pair and parse its leaf
+func loadCertificate(certFile, keyFile string) (tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return cert, fmt.Errorf("tls: %w", err)
+	}
+	if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+		return cert, fmt.Errorf("tls: %s: %w", certFile, err)
+	}
+	return cert, nil
+}
+
+// TLSEnabled reports whether the server has a certificate to serve TLS
+func (c *Core) TLSEnabled() bool {
+	cfg := c.Server.TLSConfig
+	return cfg != nil && (len(cfg.Certificates) > 0 || cfg.GetCertificate != nil || cfg.GetConfigForClient != nil)
+}
+
+// ListenAndServeTLS like ListenAndServe but serve TLS, certFile and keyFile
+// are added to the certificates of the tls config section and may be empty
+// when the section already has one. The files are reloaded when they change.
+// Works with prefork.
+func (c *Core) ListenAndServeTLS(certFile, keyFile string, addr ...string) error {
+	if c.Server.TLSConfig == nil {
+		c.Server.TLSConfig = &tls.Config{
+			MinVersion: tls.VersionTLS12,
+			NextProtos: []string{http2.NextProtoTLS, "http/1.1"},
+		}
+	}
+	if c.Certs == nil {
+		c.Certs, _ = NewCertManager()
+	}
+	if certFile != "" || keyFile != "" {
+		if err := c.Certs.AddFile(certFile, keyFile); err != nil {
+			return err
+		}
+	}
+	if !c.Certs.HasCertificates() {
+		return ErrNoCertificate
+	}
+	c.Server.TLSConfig.GetCertificate = c.Certs.GetCertificate
+	return c.ListenAndServe(addr...)
+}
+
+// wrapListener enable keep-alive on tcp listeners, the connection limits
+// and TLS when configured
+func (c *Core) wrapListener(ln net.Listener) net.Listener {
+	if tcpLn, ok := ln.(*net.TCPListener); ok {
+		ln = tcpKeepAliveListener{TCPListener: tcpLn, period: c.ListenConfig.KeepAlive}
+	}
+	ln = c.limitListener(ln)
+	if c.TLSEnabled() {
+		if c.Certs != nil {
+			c.Certs.Start()
+		}
+		ln = tlsListener{tls.NewListener(ln, c.Server.TLSConfig)}
+	}
+	return ln
+}
+
+// tlsListener mark a listener serving TLS, Serve can not read TLSConfig
+// while another listener is being served
+type tlsListener struct {
+	net.Listener
+}
+
+// EnableH2C serve HTTP/2 over cleartext connections, for internal traffic
+// behind a proxy or service mesh. HTTP/1 requests are still served.
+func (c *Core) EnableH2C() *Core {
+	c.Server.Handler = h2c.NewHandler(c, &http2.Server{
+		IdleTimeout: c.Server.IdleTimeout,
+	})
+	return c
+}
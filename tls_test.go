@@ -0,0 +1,51 @@
+package core
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeTestCert(t *testing.T, dir, name string, hosts ...string) (string, string) {
+	certPEM, keyPEM, err := GenerateSelfSigned(time.Hour, hosts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, name+".crt")
+	keyFile := filepath.Join(dir, name+".key")
+	if err = os.WriteFile(certFile, certPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.WriteFile(keyFile, keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestNewTLSConfig(t *testing.T) {
+	ca, _ := writeTestCert(t, t.TempDir(), "ca", "ca.example.com")
+	cfg, err := NewTLSConfig(Options{
+		"min_version":   "1.3",
+		"cipher_suites": []interface{}{"TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256"},
+		"client_auth":   "verify_if_given",
+		"client_ca":     ca,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS13 || cfg.ClientAuth != tls.VerifyClientCertIfGiven {
+		t.Fatalf("unexpected version or client auth: %+v", cfg)
+	}
+	if len(cfg.CipherSuites) != 1 || cfg.ClientCAs == nil || len(cfg.NextProtos) != 2 {
+		t.Fatalf("unexpected suites %v next protos %v", cfg.CipherSuites, cfg.NextProtos)
+	}
+
+	if _, err = NewTLSConfig(Options{"min_version": "2.0"}); err == nil {
+		t.Fatalf("expected error for unknown version")
+	}
+	if _, err = NewTLSConfig(Options{"cipher_suites": []interface{}{"NOPE"}}); err == nil {
+		t.Fatalf("expected error for unknown cipher suite")
+	}
+}
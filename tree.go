@@ -25,8 +25,10 @@ type params []*param
 
 type result struct {
 	preloads HandlerFuncs
+	cors     HandlerFuncs // CORS policies of the preloads
 	handler  HandlerFuncs
 	params   params
+	route    string // pattern of the matched route, e.g. /users/:id
 	static   bool
 }
 
@@ -145,12 +147,14 @@ func (n *node) child(p, m string) (child *node, ok bool) {
 func (t *tree) Find(method, path string) (*result, error) {
 	result := newResult()
 	var params params
+	var route []string
 	curNode := t.node
 	for _, p := range split(path) {
 		addPreload(curNode, result)
 		nextNode, ok := curNode.child(p, method)
 		if ok {
 			curNode = nextNode
+			route = append(route, curNode.path)
 			continue
 		}
 		if len(curNode.children) == 0 {
@@ -171,6 +175,7 @@ func (t *tree) Find(method, path string) (*result, error) {
 					value: p,
 				})
 				curNode = curNode.children[c]
+				route = append(route, c)
 				isParamMatch = true
 				break
 			}
@@ -193,6 +198,7 @@ func (t *tree) Find(method, path string) (*result, error) {
 				if string([]rune(k)[len(k)-1]) == optionalDelimiter || string(k) == ptnWildcard {
 					addPreload(v, result)
 					result.handler = v.handles[methodInt(method)]
+					route = append(route, k)
 					break
 				}
 			}
@@ -202,6 +208,7 @@ func (t *tree) Find(method, path string) (*result, error) {
 		return nil, ErrNotFound
 	}
 	result.params = params
+	result.route = slashDelimiter + strings.Join(route, slashDelimiter)
 	result.static = curNode.static
 	return result, nil
 }
@@ -210,6 +217,9 @@ func addPreload(node *node, result *result) {
 	if h, ok := node.handles[methodInt(MethodUse)]; ok && h != nil {
 		result.preloads = append(result.preloads, h...)
 	}
+	if h, ok := node.handles[methodInt(methodCORS)]; ok && h != nil {
+		result.cors = append(result.cors, h...)
+	}
 }
 
 func split(path string) []string {
@@ -0,0 +1,271 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// envInheritFDs number of listeners passed from fd 3
+	envInheritFDs = "CORE_INHERIT_FDS"
+	// envInheritKeys listen addresses of the passed listeners, comma separated
+	envInheritKeys = "CORE_INHERIT_KEYS"
+	// envReadyFD the pipe a new process closes once it serves
+	envReadyFD = "CORE_READY_FD"
+)
+
+var (
+	ErrUpgradeChild   = errors.New("upgrade: not supported in a prefork child")
+	ErrUpgradeTimeout = errors.New("upgrade: new process not ready in time")
+	ErrUpgradeExited  = errors.New("upgrade: new process exited before ready")
+
+	inheritOnce sync.Once
+	inheritMu   sync.Mutex
+	inherited   []inheritedListener
+	readyOnce   sync.Once
+)
+
+// inheritedListener a listener and the listen address it was opened for
+type inheritedListener struct {
+	key string
+	ln  net.Listener
+}
+
+// inheritedListeners returns the listeners passed by the parent process,
+// they are taken from the environment once.
+func inheritedListeners() []inheritedListener {
+	inheritOnce.Do(func() {
+		n, _ := strconv.Atoi(os.Getenv(envInheritFDs))
+		keys := strings.Split(os.Getenv(envInheritKeys), ",")
+		os.Unsetenv(envInheritFDs)
+		os.Unsetenv(envInheritKeys)
+		for i := 0; i < n; i++ {
+			f := os.NewFile(uintptr(3+i), "listener")
+			ln, err := net.FileListener(f)
+			f.Close()
+			if err != nil {
+				Erro("upgrade: inherit fd %d: %s", 3+i, err)
+				continue
+			}
+			il := inheritedListener{ln: ln}
+			if i < len(keys) {
+				il.key = keys[i]
+			}
+			inherited = append(inherited, il)
+		}
+	})
+	return inherited
+}
+
+// listenKey returns the listen address of network and addr
+func listenKey(network, addr string) string {
+	if network == "unix" {
+		return "unix:" + addr
+	}
+	return addr
+}
+
+// takeInherited returns the inherited listener for network and addr, for
+// systemd addr is the key and "systemd" matches every systemd socket
+func takeInherited(network, addr string) (inheritedListener, bool) {
+	inheritMu.Lock()
+	defer inheritMu.Unlock()
+	lns := inheritedListeners()
+	key := listenKey(network, addr)
+	for i, il := range lns {
+		match := il.key == key
+		switch {
+		case network == "systemd":
+			match = match || (key == "systemd" && strings.HasPrefix(il.key, "systemd"))
+		case !strings.HasPrefix(il.key, "systemd"):
+			match = match || sameAddr(network, addr, il.ln.Addr())
+		}
+		if match {
+			inherited = append(lns[:i:i], lns[i+1:]...)
+			return il, true
+		}
+	}
+	return inheritedListener{}, false
+}
+
+func sameAddr(network, addr string, la net.Addr) bool {
+	if la.Network() != "tcp" || !strings.HasPrefix(network, "tcp") {
+		return la.Network() == network && la.String() == addr
+	}
+	want, err := net.ResolveTCPAddr(network, addr)
+	if err != nil {
+		return false
+	}
+	got := la.(*net.TCPAddr)
+	if want.Port != got.Port {
+		return false
+	}
+	if want.IP == nil || want.IP.IsUnspecified() {
+		return got.IP.IsUnspecified()
+	}
+	return want.IP.Equal(got.IP)
+}
+
+// listen take the listener inherited from an upgrade or create it, the
+// listener is recorded to be passed on the next upgrade
+func (c *Core) listen(network, addr string, listen func(network, addr string) (net.Listener, error)) (net.Listener, error) {
+	il, ok := takeInherited(network, addr)
+	ln := il.ln
+	if !ok {
+		var err error
+		if ln, err = listen(network, addr); err != nil {
+			return nil, err
+		}
+	}
+	c.life.mu.Lock()
+	c.listeners = append(c.listeners, inheritedListener{key: listenKey(network, addr), ln: ln})
+	if c.Ln == nil {
+		c.Ln = ln
+	}
+	c.life.mu.Unlock()
+	return ln, nil
+}
+
+// listenerFiles dup the recorded listeners and returns their listen addresses
+func (c *Core) listenerFiles() ([]*os.File, []string, error) {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	files := make([]*os.File, 0, len(c.listeners))
+	keys := make([]string, 0, len(c.listeners))
+	for _, il := range c.listeners {
+		fl, ok := il.ln.(interface{ File() (*os.File, error) })
+		if !ok {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("upgrade: listener %s can not be passed", il.ln.Addr())
+		}
+		f, err := fl.File()
+		if err != nil {
+			closeFiles(files)
+			return nil, nil, fmt.Errorf("upgrade: %w", err)
+		}
+		files = append(files, f)
+		keys = append(keys, il.key)
+	}
+	return files, keys, nil
+}
+
+// keepUnixSockets leave the socket files to the process which inherits them
+func (c *Core) keepUnixSockets() {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	for _, il := range c.listeners {
+		if ul, ok := il.ln.(*net.UnixListener); ok {
+			ul.SetUnlinkOnClose(false)
+		}
+	}
+}
+
+func (c *Core) restoreNonblock() {
+	c.life.mu.Lock()
+	defer c.life.mu.Unlock()
+	lns := make([]net.Listener, 0, len(c.listeners))
+	for _, il := range c.listeners {
+		lns = append(lns, il.ln)
+	}
+	restoreNonblock(lns)
+}
+
+func closeFiles(files []*os.File) {
+	for _, f := range files {
+		f.Close()
+	}
+}
+
+// inheritEnv returns the environment for a process which inherits the
+// listeners of keys
+func inheritEnv(keys []string, extra ...string) []string {
+	env := make([]string, 0, len(os.Environ())+len(extra)+2)
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, envInheritFDs+"=") || strings.HasPrefix(kv, envInheritKeys+"=") ||
+			strings.HasPrefix(kv, envReadyFD+"=") || strings.HasPrefix(kv, envIPCFD+"=") {
+			continue
+		}
+		env = append(env, kv)
+	}
+	env = append(env,
+		fmt.Sprintf("%s=%d", envInheritFDs, len(keys)),
+		fmt.Sprintf("%s=%s", envInheritKeys, strings.Join(keys, ",")))
+	return append(env, extra...)
+}
+
+// Upgrade start a new process of the binary which inherits the listeners and
+// wait until it serves, the caller then shuts down to drain. The new process
+// is killed when it is not ready within upgrade_timeout, default 30s.
+//
+// NewEngine calls it on SIGUSR2. Replace the binary and send SIGUSR2 for a
+// zero-downtime upgrade.
+func (c *Core) Upgrade() error {
+	if IsChild() {
+		return ErrUpgradeChild
+	}
+	files, keys, err := c.listenerFiles()
+	if err != nil {
+		return err
+	}
+	defer closeFiles(files)
+	r, w, err := os.Pipe()
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+	defer r.Close()
+
+	cmd := exec.Command(os.Args[0], os.Args[1:]...) // nolint:gosec
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	cmd.ExtraFiles = append(files, w)
+	cmd.Env = inheritEnv(keys, fmt.Sprintf("%s=%d", envReadyFD, 3+len(files)))
+	err = cmd.Start()
+	w.Close()
+	c.restoreNonblock()
+	if err != nil {
+		return fmt.Errorf("upgrade: %w", err)
+	}
+
+	ready := make(chan error, 1)
+	go func() {
+		// the pipe gets EOF once the new process closes it, or exits
+		_, err := r.Read(make([]byte, 1))
+		ready <- err
+	}()
+	timeout := c.Conf.GetDuration("upgrade_timeout", 30*time.Second)
+	select {
+	case err = <-ready:
+		if err == nil {
+			Info("upgrade: new process %d ready", cmd.Process.Pid)
+			c.keepUnixSockets()
+			return cmd.Process.Release()
+		}
+		err = ErrUpgradeExited
+	case <-time.After(timeout):
+		err = ErrUpgradeTimeout
+	}
+	cmd.Process.Kill()
+	cmd.Wait()
+	return err
+}
+
+// notifyReady tell the parent process of an upgrade that this one serves
+func notifyReady() {
+	readyOnce.Do(func() {
+		fd, err := strconv.Atoi(os.Getenv(envReadyFD))
+		if err != nil {
+			return
+		}
+		os.Unsetenv(envReadyFD)
+		f := os.NewFile(uintptr(fd), "ready")
+		f.Write([]byte{1})
+		f.Close()
+	})
+}
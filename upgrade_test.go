@@ -0,0 +1,59 @@
+package core
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+	"time"
+)
+
+// TestUpgrade runs twice, the second time as the new process started by Upgrade
+func TestUpgrade(t *testing.T) {
+	addr := os.Getenv("CORE_TEST_UPGRADE_ADDR")
+	if os.Getenv(envInheritFDs) != "" {
+		app := New()
+		app.Get("/", func(c *Ctx) {
+			c.SendString("new")
+			go app.Shutdown()
+		})
+		ln, err := app.listen("tcp", addr, net.Listen)
+		if err != nil {
+			t.Fatal(err)
+		}
+		time.AfterFunc(5*time.Second, func() { app.Server.Close() })
+		app.Serve(ln)
+		return
+	}
+
+	app := New()
+	app.Get("/", func(c *Ctx) { c.SendString("old") })
+	ln, err := app.listen("tcp", "127.0.0.1:0", net.Listen)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go app.Serve(ln)
+
+	args := os.Args
+	os.Args = []string{args[0], "-test.run=^TestUpgrade$"}
+	os.Setenv("CORE_TEST_UPGRADE_ADDR", ln.Addr().String())
+	defer func() {
+		os.Args = args
+		os.Unsetenv("CORE_TEST_UPGRADE_ADDR")
+	}()
+	if err = app.Upgrade(); err != nil {
+		t.Fatal(err)
+	}
+	if err = app.Shutdown(); err != nil {
+		t.Fatal(err)
+	}
+	resp, err := http.Get("http://" + ln.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if body, _ := io.ReadAll(resp.Body); string(body) != "new" {
+		t.Fatalf("expected the new process to serve, got %q", body)
+	}
+}
@@ -660,6 +660,7 @@ const (
 	MIMEApplicationForm       = "application/x-www-form-urlencoded"
 	MIMEOctetStream           = "application/octet-stream"
 	MIMEMultipartForm         = "multipart/form-data"
+	MIMETextEventStream       = "text/event-stream"
 
 	MIMETextXMLCharsetUTF8               = "text/xml; charset=utf-8"
 	MIMETextHTMLCharsetUTF8              = "text/html; charset=utf-8"
@@ -699,6 +700,9 @@ var Methods = []string{
 
 // HTTP methods and their unique INTs
 func methodInt(s string) int8 {
+	if s == methodCORS {
+		return -2
+	}
 	for i, v := range Methods {
 		if strings.Compare(s, v) == 0 {
 			return int8(i)
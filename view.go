@@ -359,6 +359,8 @@ var bufPool = sync.Pool{
 }
 
 var templateHelpers = template.FuncMap{
+	// hidden input of the CSRF token, {{ csrfField . }}
+	"csrfField": csrfField,
 	"nl2br": func(text string) template.HTML {
 		return template.HTML(strings.Replace(template.HTMLEscapeString(text), "\n", "<br />", -1))
 	},
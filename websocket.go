@@ -0,0 +1,911 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/bytedance/sonic"
+)
+
+// WebSocket message types, see RFC 6455 section 11.8.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+	CloseMessage  = 8
+	PingMessage   = 9
+	PongMessage   = 10
+
+	continuationFrame = 0
+)
+
+// WebSocket close codes, see RFC 6455 section 11.7.
+const (
+	CloseNormalClosure           = 1000
+	CloseGoingAway               = 1001
+	CloseProtocolError           = 1002
+	CloseUnsupportedData         = 1003
+	CloseNoStatusReceived        = 1005
+	CloseAbnormalClosure         = 1006
+	CloseInvalidFramePayloadData = 1007
+	ClosePolicyViolation         = 1008
+	CloseMessageTooBig           = 1009
+	CloseMandatoryExtension      = 1010
+	CloseInternalServerErr       = 1011
+	CloseServiceRestart          = 1012
+	CloseTryAgainLater           = 1013
+	CloseTLSHandshake            = 1015
+)
+
+const (
+	wsGUID                 = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	wsMaxControlPayload    = 125
+	wsDefaultReadLimit     = 32 << 20 // 32 MB
+	wsDefaultFrameSize     = 4096
+	wsDefaultCompressLimit = 512
+)
+
+var (
+	ErrWSBadHandshake     = NewError(StatusBadRequest, "websocket: bad handshake")
+	ErrWSOriginNotAllowed = NewError(StatusForbidden, "websocket: origin not allowed")
+	ErrWSVersion          = NewError(StatusUpgradeRequired, "websocket: unsupported version")
+	ErrWSNotSupported     = NewError(StatusInternalServerError, "websocket: response does not implement http.Hijacker")
+	ErrWSCloseSent        = errors.New("websocket: close sent")
+	ErrWSReadLimit        = errors.New("websocket: read limit exceeded")
+	ErrWSInvalidControl   = errors.New("websocket: invalid control frame")
+	ErrWSInvalidMessage   = errors.New("websocket: invalid message type")
+
+	// deflate tail appended to a compressed message before inflating it,
+	// RFC 7692 section 7.2.2 plus an empty final block.
+	wsDeflateTail = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+	flateWriterPool = sync.Pool{New: func() interface{} {
+		fw, _ := flate.NewWriter(nil, flate.BestSpeed)
+		return fw
+	}}
+	flateReaderPool = sync.Pool{New: func() interface{} {
+		return flate.NewReader(nil)
+	}}
+)
+
+// WebSocketConfig configure the websocket handshake and connection
+type WebSocketConfig struct {
+	// Origins allowed to connect. "*" allows any origin, "*.example.com"
+	// allows every subdomain of example.com. An empty list only allows
+	// requests without Origin or from the same host.
+	Origins []string
+
+	// CheckOrigin overrides Origins when set.
+	CheckOrigin func(c *Ctx) bool
+
+	// Subprotocols supported by the server in order of preference.
+	Subprotocols []string
+
+	// EnableCompression negotiates permessage-deflate if the client offers it.
+	EnableCompression bool
+
+	// CompressionThreshold messages smaller than it are sent uncompressed.
+	// default 512 bytes
+	CompressionThreshold int
+
+	// ReadLimit maximum size in bytes of a message read from the peer.
+	// default 32 MB
+	ReadLimit int64
+
+	// FrameSize maximum payload of a frame written by NextWriter before the
+	// message is fragmented. default 4096
+	FrameSize int
+
+	// HandshakeTimeout bounds the time to write the handshake response.
+	HandshakeTimeout time.Duration
+}
+
+func wsConfigDefault(conf ...WebSocketConfig) WebSocketConfig {
+	cfg := WebSocketConfig{}
+	if len(conf) > 0 {
+		cfg = conf[0]
+	}
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = wsDefaultReadLimit
+	}
+	if cfg.FrameSize <= 0 {
+		cfg.FrameSize = wsDefaultFrameSize
+	}
+	if cfg.CompressionThreshold <= 0 {
+		cfg.CompressionThreshold = wsDefaultCompressLimit
+	}
+	return cfg
+}
+
+// WebSocket returns a HandlerFunc which upgrades the request and calls handler
+//
+//	app.Get("/ws", core.WebSocket(func(conn *core.WSConn) {
+//		for {
+//			mt, msg, err := conn.ReadMessage()
+//			if err != nil {
+//				return
+//			}
+//			conn.WriteMessage(mt, msg)
+//		}
+//	}))
+func WebSocket(handler func(*WSConn), conf ...WebSocketConfig) HandlerFunc {
+	return func(c *Ctx) {
+		c.WebSocket(handler, conf...)
+	}
+}
+
+// IsWebSocket reports whether the request asks for a websocket upgrade.
+func (c *Ctx) IsWebSocket() bool {
+	return headerHasToken(c.R.Header, "Connection", "upgrade") &&
+		headerHasToken(c.R.Header, HeaderUpgrade, "websocket")
+}
+
+// WebSocket upgrades the request to a websocket connection and calls handler.
+// Middlewares and Preloads have already run on c, values set with c.Set are
+// available through conn.Ctx. The connection is closed when handler returns.
+// If the handshake fails an error response is written and the error returned.
+func (c *Ctx) WebSocket(handler func(*WSConn), conf ...WebSocketConfig) error {
+	cfg := wsConfigDefault(conf...)
+	c.Abort() // the connection is hijacked, nothing else may write to it
+	key, err := c.wsCheckHandshake(&cfg)
+	if err != nil {
+		if e, ok := err.(*Error); ok {
+			if e.Code == StatusUpgradeRequired {
+				c.SetHeader("Sec-WebSocket-Version", "13")
+			}
+			c.SendStatus(e.Code, e.Message)
+		}
+		return err
+	}
+
+	protocol := wsSelectProtocol(cfg.Subprotocols, c.R.Header)
+	compress := cfg.EnableCompression && wsNegotiateDeflate(c.R.Header)
+
+	c.Status(StatusSwitchingProtocols)
+	conn, brw, err := c.W.Hijack()
+	if err != nil {
+		return err
+	}
+	// clear deadlines set by http.Server
+	conn.SetDeadline(time.Time{})
+	if cfg.HandshakeTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(cfg.HandshakeTimeout))
+	}
+
+	buf := bytes.NewBufferString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	buf.WriteString("Sec-WebSocket-Accept: " + wsAcceptKey(key) + "\r\n")
+	if protocol != "" {
+		buf.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	if compress {
+		buf.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	for k, vs := range c.W.Header() { // headers set by middlewares, e.g. cookies
+		switch k {
+		case "Upgrade", "Connection", "Content-Type", "Content-Length", "Transfer-Encoding":
+			continue
+		}
+		for _, v := range vs {
+			buf.WriteString(k + ": " + v + "\r\n")
+		}
+	}
+	buf.WriteString("\r\n")
+	if _, err = brw.Writer.Write(buf.Bytes()); err == nil {
+		err = brw.Writer.Flush()
+	}
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	if cfg.HandshakeTimeout > 0 {
+		conn.SetWriteDeadline(time.Time{})
+	}
+
+	ws := newWSConn(c, conn, brw, &cfg, protocol, compress)
+	defer ws.Close()
+	handler(ws)
+	return nil
+}
+
+func (c *Ctx) wsCheckHandshake(cfg *WebSocketConfig) (string, error) {
+	if c.R.Method != MethodGet || !c.IsWebSocket() {
+		return "", ErrWSBadHandshake
+	}
+	if c.GetHeader("Sec-WebSocket-Version") != "13" {
+		return "", ErrWSVersion
+	}
+	key := strings.TrimSpace(c.GetHeader("Sec-WebSocket-Key"))
+	if raw, err := base64.StdEncoding.DecodeString(key); err != nil || len(raw) != 16 {
+		return "", ErrWSBadHandshake
+	}
+	if !cfg.allowOrigin(c) {
+		return "", ErrWSOriginNotAllowed
+	}
+	if _, ok := c.Resp.(http.Hijacker); !ok {
+		return "", ErrWSNotSupported
+	}
+	return key, nil
+}
+
+func (cfg *WebSocketConfig) allowOrigin(c *Ctx) bool {
+	if cfg.CheckOrigin != nil {
+		return cfg.CheckOrigin(c)
+	}
+	origin := c.GetHeader("Origin")
+	if origin == "" {
+		return true
+	}
+	if len(cfg.Origins) == 0 {
+		u, err := url.Parse(origin)
+		return err == nil && strings.EqualFold(u.Host, c.R.Host)
+	}
+	for _, pattern := range cfg.Origins {
+		if matchOrigin(pattern, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchOrigin match origin against pattern
+//
+//	"*"                         any origin
+//	"https://example.com"       exact origin
+//	"example.com"               host only, any scheme
+//	"*.example.com"             any subdomain of example.com, any scheme
+//	"https://*.example.com"     any subdomain of example.com over https
+func matchOrigin(pattern, origin string) bool {
+	if pattern == "*" {
+		return true
+	}
+	pattern = strings.ToLower(pattern)
+	origin = strings.ToLower(origin)
+	if !strings.Contains(pattern, "://") {
+		u, err := url.Parse(origin)
+		if err != nil {
+			return false
+		}
+		origin = u.Host
+	}
+	if i := strings.Index(pattern, "*."); i >= 0 {
+		prefix, suffix := pattern[:i], pattern[i+1:]
+		return strings.HasPrefix(origin, prefix) && strings.HasSuffix(origin, suffix) &&
+			len(origin) > len(prefix)+len(suffix)
+	}
+	return pattern == origin
+}
+
+func wsAcceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+func wsSelectProtocol(supported []string, h http.Header) string {
+	if len(supported) == 0 {
+		return ""
+	}
+	offered := headerTokens(h, "Sec-WebSocket-Protocol")
+	for _, s := range supported {
+		for _, o := range offered {
+			if s == o {
+				return s
+			}
+		}
+	}
+	return ""
+}
+
+// wsNegotiateDeflate accept the first permessage-deflate offer we can honor,
+// both directions run without context takeover so messages are independent.
+func wsNegotiateDeflate(h http.Header) bool {
+	for _, ext := range headerTokens(h, "Sec-WebSocket-Extensions") {
+		params := strings.Split(ext, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch strings.TrimSpace(k) {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits": // flate always uses a 32K window
+				ok = strings.Trim(strings.TrimSpace(v), `"`) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+// headerTokens returns the comma separated values of header k
+func headerTokens(h http.Header, k string) []string {
+	tokens := make([]string, 0)
+	for _, v := range h.Values(k) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				tokens = append(tokens, t)
+			}
+		}
+	}
+	return tokens
+}
+
+func headerHasToken(h http.Header, k, token string) bool {
+	for _, t := range headerTokens(h, k) {
+		if strings.EqualFold(t, token) {
+			return true
+		}
+	}
+	return false
+}
+
+// CloseError is returned by ReadMessage when the peer sends a close frame
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+// IsCloseError reports whether err is a *CloseError with one of codes,
+// any close code matches when codes is empty.
+func IsCloseError(err error, codes ...int) bool {
+	var ce *CloseError
+	if !errors.As(err, &ce) {
+		return false
+	}
+	if len(codes) == 0 {
+		return true
+	}
+	for _, code := range codes {
+		if ce.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// FormatCloseMessage formats code and text as a close frame payload
+func FormatCloseMessage(code int, text string) []byte {
+	if code == CloseNoStatusReceived {
+		return []byte{}
+	}
+	buf := make([]byte, 2+len(text))
+	binary.BigEndian.PutUint16(buf, uint16(code))
+	copy(buf[2:], text)
+	return buf
+}
+
+func isControlMessage(mt int) bool {
+	return mt == CloseMessage || mt == PingMessage || mt == PongMessage
+}
+
+func isValidCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1003, code >= 1007 && code <= 1014:
+		return true
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// WSConn a server side websocket connection.
+//
+// ReadMessage must be called from one goroutine at a time, writes are
+// serialized so WriteMessage may be called concurrently.
+type WSConn struct {
+	// Ctx the request context of the upgrade, valid until the handler returns
+	Ctx *Ctx
+
+	conn      net.Conn
+	br        *bufio.Reader
+	bw        *bufio.Writer
+	cfg       *WebSocketConfig
+	protocol  string
+	compress  bool
+	readLimit int64
+
+	mu        sync.Mutex // guards frame writes
+	msgMu     sync.Mutex // held by a NextWriter until closed
+	closeSent bool
+	closeOnce sync.Once
+	readErr   error
+
+	handlePing  func(data string) error
+	handlePong  func(data string) error
+	handleClose func(code int, text string) error
+}
+
+func newWSConn(c *Ctx, conn net.Conn, brw *bufio.ReadWriter, cfg *WebSocketConfig, protocol string, compress bool) *WSConn {
+	ws := &WSConn{
+		Ctx:       c,
+		conn:      conn,
+		br:        brw.Reader,
+		bw:        brw.Writer,
+		cfg:       cfg,
+		protocol:  protocol,
+		compress:  compress,
+		readLimit: cfg.ReadLimit,
+	}
+	ws.handlePing = func(data string) error {
+		err := ws.WriteControl(PongMessage, []byte(data), time.Now().Add(time.Second))
+		if err == ErrWSCloseSent {
+			return nil
+		}
+		return err
+	}
+	ws.handleClose = func(code int, text string) error {
+		if code == CloseNoStatusReceived {
+			code = CloseNormalClosure
+		}
+		ws.WriteControl(CloseMessage, FormatCloseMessage(code, ""), time.Now().Add(time.Second))
+		return nil
+	}
+	return ws
+}
+
+// Subprotocol returns the negotiated subprotocol
+func (ws *WSConn) Subprotocol() string {
+	return ws.protocol
+}
+
+// Compressed reports whether permessage-deflate was negotiated
+func (ws *WSConn) Compressed() bool {
+	return ws.compress
+}
+
+// SetReadLimit sets the maximum size in bytes of a message read from the peer
+func (ws *WSConn) SetReadLimit(limit int64) {
+	ws.readLimit = limit
+}
+
+// SetPingHandler sets the handler for ping messages, the default replies with a pong
+func (ws *WSConn) SetPingHandler(h func(data string) error) {
+	if h == nil {
+		h = func(string) error { return nil }
+	}
+	ws.handlePing = h
+}
+
+// SetPongHandler sets the handler for pong messages
+func (ws *WSConn) SetPongHandler(h func(data string) error) {
+	ws.handlePong = h
+}
+
+// SetCloseHandler sets the handler for close messages, the default echoes the close code
+func (ws *WSConn) SetCloseHandler(h func(code int, text string) error) {
+	if h == nil {
+		h = func(int, string) error { return nil }
+	}
+	ws.handleClose = h
+}
+
+func (ws *WSConn) SetReadDeadline(t time.Time) error {
+	return ws.conn.SetReadDeadline(t)
+}
+
+func (ws *WSConn) SetWriteDeadline(t time.Time) error {
+	return ws.conn.SetWriteDeadline(t)
+}
+
+func (ws *WSConn) RemoteAddr() net.Addr {
+	return ws.conn.RemoteAddr()
+}
+
+func (ws *WSConn) LocalAddr() net.Addr {
+	return ws.conn.LocalAddr()
+}
+
+// UnderlyingConn returns the hijacked net.Conn
+func (ws *WSConn) UnderlyingConn() net.Conn {
+	return ws.conn
+}
+
+type wsFrame struct {
+	fin     bool
+	rsv1    bool
+	opcode  int
+	payload []byte
+}
+
+// ReadMessage reads the next data message, control frames received in
+// between are passed to the ping, pong and close handlers. A close frame
+// from the peer is returned as *CloseError.
+func (ws *WSConn) ReadMessage() (messageType int, p []byte, err error) {
+	if ws.readErr != nil {
+		return 0, nil, ws.readErr
+	}
+	compressed := false
+	for {
+		f, err := ws.readFrame(int64(len(p)))
+		if err != nil {
+			ws.readErr = err
+			return 0, nil, err
+		}
+		switch f.opcode {
+		case PingMessage, PongMessage, CloseMessage:
+			if err = ws.handleControl(f); err != nil {
+				ws.readErr = err
+				return 0, nil, err
+			}
+			continue
+		case TextMessage, BinaryMessage:
+			if messageType != 0 {
+				return ws.fail(CloseProtocolError, "continuation frame expected")
+			}
+			messageType = f.opcode
+			compressed = f.rsv1
+		case continuationFrame:
+			if messageType == 0 {
+				return ws.fail(CloseProtocolError, "unexpected continuation frame")
+			}
+			if f.rsv1 {
+				return ws.fail(CloseProtocolError, "rsv1 set on continuation frame")
+			}
+		}
+		p = append(p, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+	if compressed {
+		if p, err = ws.inflate(p); err != nil {
+			if err == ErrWSReadLimit {
+				return ws.fail(CloseMessageTooBig, err.Error())
+			}
+			return ws.fail(CloseInvalidFramePayloadData, err.Error())
+		}
+	}
+	if messageType == TextMessage && !utf8.Valid(p) {
+		return ws.fail(CloseInvalidFramePayloadData, "invalid utf8 payload")
+	}
+	return messageType, p, nil
+}
+
+func (ws *WSConn) readFrame(read int64) (f wsFrame, err error) {
+	var h [8]byte
+	if _, err = io.ReadFull(ws.br, h[:2]); err != nil {
+		return
+	}
+	f.fin = h[0]&0x80 != 0
+	f.rsv1 = h[0]&0x40 != 0
+	f.opcode = int(h[0] & 0x0f)
+	masked := h[1]&0x80 != 0
+	length := int64(h[1] & 0x7f)
+	switch length {
+	case 126:
+		if _, err = io.ReadFull(ws.br, h[:2]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint16(h[:2]))
+	case 127:
+		if _, err = io.ReadFull(ws.br, h[:8]); err != nil {
+			return
+		}
+		length = int64(binary.BigEndian.Uint64(h[:8]))
+	}
+
+	switch {
+	case h[0]&0x30 != 0:
+		_, _, err = ws.fail(CloseProtocolError, "reserved bits set")
+	case f.rsv1 && (!ws.compress || isControlMessage(f.opcode)):
+		_, _, err = ws.fail(CloseProtocolError, "unexpected rsv1 bit")
+	case f.opcode != continuationFrame && f.opcode != TextMessage && f.opcode != BinaryMessage && !isControlMessage(f.opcode):
+		_, _, err = ws.fail(CloseProtocolError, fmt.Sprintf("unknown opcode %d", f.opcode))
+	case isControlMessage(f.opcode) && (!f.fin || length > wsMaxControlPayload):
+		_, _, err = ws.fail(CloseProtocolError, "invalid control frame")
+	case !masked:
+		_, _, err = ws.fail(CloseProtocolError, "client frame not masked")
+	case length < 0 || (ws.readLimit > 0 && !isControlMessage(f.opcode) && read+length > ws.readLimit):
+		_, _, err = ws.fail(CloseMessageTooBig, ErrWSReadLimit.Error())
+	}
+	if err != nil {
+		return
+	}
+
+	var mask [4]byte
+	if _, err = io.ReadFull(ws.br, mask[:]); err != nil {
+		return
+	}
+	f.payload = make([]byte, length)
+	if _, err = io.ReadFull(ws.br, f.payload); err != nil {
+		return
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return
+}
+
+func (ws *WSConn) handleControl(f wsFrame) error {
+	switch f.opcode {
+	case PingMessage:
+		return ws.handlePing(string(f.payload))
+	case PongMessage:
+		if ws.handlePong != nil {
+			return ws.handlePong(string(f.payload))
+		}
+		return nil
+	}
+	code, text := CloseNoStatusReceived, ""
+	switch {
+	case len(f.payload) == 1:
+		_, _, err := ws.fail(CloseProtocolError, "invalid close payload")
+		return err
+	case len(f.payload) >= 2:
+		code = int(binary.BigEndian.Uint16(f.payload))
+		text = string(f.payload[2:])
+		if !isValidCloseCode(code) {
+			_, _, err := ws.fail(CloseProtocolError, "invalid close code")
+			return err
+		}
+		if !utf8.ValidString(text) {
+			_, _, err := ws.fail(CloseInvalidFramePayloadData, "invalid utf8 close reason")
+			return err
+		}
+	}
+	if err := ws.handleClose(code, text); err != nil {
+		return err
+	}
+	return &CloseError{Code: code, Text: text}
+}
+
+// fail sends a close frame with code and returns the matching error
+func (ws *WSConn) fail(code int, text string) (int, []byte, error) {
+	ws.WriteControl(CloseMessage, FormatCloseMessage(code, text), time.Now().Add(time.Second))
+	err := fmt.Errorf("websocket: %s", text)
+	ws.readErr = err
+	return 0, nil, err
+}
+
+func (ws *WSConn) inflate(p []byte) ([]byte, error) {
+	fr := flateReaderPool.Get().(io.ReadCloser)
+	defer flateReaderPool.Put(fr)
+	if err := fr.(flate.Resetter).Reset(io.MultiReader(bytes.NewReader(p), bytes.NewReader(wsDeflateTail)), nil); err != nil {
+		return nil, err
+	}
+	limit := ws.readLimit
+	if limit <= 0 {
+		limit = 1<<63 - 2
+	}
+	out, err := io.ReadAll(io.LimitReader(fr, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(out)) > limit {
+		return nil, ErrWSReadLimit
+	}
+	return out, nil
+}
+
+// WriteControl writes a close, ping or pong message, it may be called
+// concurrently with other writes and while a NextWriter is open.
+func (ws *WSConn) WriteControl(messageType int, data []byte, deadline time.Time) error {
+	if !isControlMessage(messageType) || len(data) > wsMaxControlPayload {
+		return ErrWSInvalidControl
+	}
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+	if !deadline.IsZero() {
+		ws.conn.SetWriteDeadline(deadline)
+		defer ws.conn.SetWriteDeadline(time.Time{})
+	}
+	if err := ws.writeFrame(true, false, messageType, data); err != nil {
+		return err
+	}
+	if messageType == CloseMessage {
+		ws.closeSent = true
+	}
+	return nil
+}
+
+// Ping sends a ping message with data
+func (ws *WSConn) Ping(data []byte) error {
+	return ws.WriteControl(PingMessage, data, time.Time{})
+}
+
+// WriteMessage writes a complete text or binary message, control message
+// types are delegated to WriteControl.
+func (ws *WSConn) WriteMessage(messageType int, data []byte) error {
+	if isControlMessage(messageType) {
+		return ws.WriteControl(messageType, data, time.Time{})
+	}
+	w, err := ws.nextWriter(messageType, ws.compress && len(data) >= ws.cfg.CompressionThreshold)
+	if err != nil {
+		return err
+	}
+	if _, err = w.Write(data); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// WriteJSON writes v as a JSON text message
+func (ws *WSConn) WriteJSON(v interface{}) error {
+	raw, err := sonic.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ws.WriteMessage(TextMessage, raw)
+}
+
+// ReadJSON reads the next message and decodes it into v
+func (ws *WSConn) ReadJSON(v interface{}) error {
+	_, p, err := ws.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(p, v)
+}
+
+// NextWriter returns a writer for the next message, payloads larger than
+// FrameSize are sent as fragmented frames. Other data messages wait until
+// the writer is closed.
+func (ws *WSConn) NextWriter(messageType int) (io.WriteCloser, error) {
+	return ws.nextWriter(messageType, ws.compress)
+}
+
+func (ws *WSConn) nextWriter(messageType int, compress bool) (io.WriteCloser, error) {
+	if messageType != TextMessage && messageType != BinaryMessage {
+		return nil, ErrWSInvalidMessage
+	}
+	ws.msgMu.Lock()
+	ws.mu.Lock()
+	closed := ws.closeSent
+	ws.mu.Unlock()
+	if closed {
+		ws.msgMu.Unlock()
+		return nil, ErrWSCloseSent
+	}
+	w := &wsMessageWriter{ws: ws, opcode: messageType, compress: compress}
+	if compress {
+		w.fw = flateWriterPool.Get().(*flate.Writer)
+		w.fw.Reset(wsBufWriter{w})
+	}
+	return w, nil
+}
+
+func (ws *WSConn) writeFrame(fin, rsv1 bool, opcode int, payload []byte) error {
+	if ws.closeSent {
+		return ErrWSCloseSent
+	}
+	var h [10]byte
+	h[0] = byte(opcode)
+	if fin {
+		h[0] |= 0x80
+	}
+	if rsv1 {
+		h[0] |= 0x40
+	}
+	n := 2
+	switch l := len(payload); {
+	case l <= 125:
+		h[1] = byte(l)
+	case l <= 0xffff:
+		h[1] = 126
+		binary.BigEndian.PutUint16(h[2:], uint16(l))
+		n += 2
+	default:
+		h[1] = 127
+		binary.BigEndian.PutUint64(h[2:], uint64(l))
+		n += 8
+	}
+	if _, err := ws.bw.Write(h[:n]); err != nil {
+		return err
+	}
+	if _, err := ws.bw.Write(payload); err != nil {
+		return err
+	}
+	return ws.bw.Flush()
+}
+
+// Close sends a normal closure frame and closes the underlying connection
+func (ws *WSConn) Close() error {
+	return ws.CloseWithCode(CloseNormalClosure, "")
+}
+
+// CloseWithCode sends a close frame with code and text then closes the
+// underlying connection
+func (ws *WSConn) CloseWithCode(code int, text string) error {
+	err := ErrWSCloseSent
+	ws.closeOnce.Do(func() {
+		ws.WriteControl(CloseMessage, FormatCloseMessage(code, text), time.Now().Add(time.Second))
+		err = ws.conn.Close()
+	})
+	return err
+}
+
+type wsMessageWriter struct {
+	ws       *WSConn
+	opcode   int
+	compress bool
+	fw       *flate.Writer
+	buf      []byte
+	closed   bool
+}
+
+// wsBufWriter collects deflate output into the message buffer
+type wsBufWriter struct {
+	w *wsMessageWriter
+}
+
+func (b wsBufWriter) Write(p []byte) (int, error) {
+	b.w.buf = append(b.w.buf, p...)
+	return len(p), b.w.flushFrames()
+}
+
+func (w *wsMessageWriter) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, ErrWSCloseSent
+	}
+	if w.compress {
+		return w.fw.Write(p)
+	}
+	w.buf = append(w.buf, p...)
+	return len(p), w.flushFrames()
+}
+
+// flushFrames writes full frames, when compressing the last 4 bytes are
+// kept back as they may be the deflate tail stripped on Close.
+func (w *wsMessageWriter) flushFrames() error {
+	size := w.ws.cfg.FrameSize
+	keep := 0
+	if w.compress {
+		keep = 4
+	}
+	for len(w.buf)-keep > size {
+		if err := w.frame(false, w.buf[:size]); err != nil {
+			return err
+		}
+		w.buf = w.buf[size:]
+	}
+	return nil
+}
+
+func (w *wsMessageWriter) frame(fin bool, payload []byte) error {
+	w.ws.mu.Lock()
+	defer w.ws.mu.Unlock()
+	rsv1 := w.compress && w.opcode != continuationFrame
+	err := w.ws.writeFrame(fin, rsv1, w.opcode, payload)
+	w.opcode = continuationFrame
+	return err
+}
+
+func (w *wsMessageWriter) Close() error {
+	if w.closed {
+		return nil
+	}
+	w.closed = true
+	defer w.ws.msgMu.Unlock()
+	if w.compress {
+		err := w.fw.Flush()
+		flateWriterPool.Put(w.fw)
+		if err != nil {
+			return err
+		}
+		w.buf = bytes.TrimSuffix(w.buf, wsDeflateTail[:4])
+	}
+	return w.frame(true, w.buf)
+}
@@ -0,0 +1,172 @@
+package core
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func wsServe(t *testing.T, handler HandlerFunc) (*InmemoryListener, func()) {
+	ln := NewInmemoryListener()
+	app := New()
+	app.Get("/ws", handler)
+	go app.Serve(ln)
+	return ln, func() { ln.Close() }
+}
+
+func wsDial(t *testing.T, ln *InmemoryListener, header string) (net.Conn, *bufio.Reader, *http.Response) {
+	conn, err := ln.Dial()
+	if err != nil {
+		t.Fatalf("dial: %s", err)
+	}
+	req := "GET /ws HTTP/1.1\r\nHost: unused.host\r\nConnection: Upgrade\r\nUpgrade: websocket\r\n" +
+		"Sec-WebSocket-Version: 13\r\nSec-WebSocket-Key: dGhlIHNhbXBsZSBub25jZQ==\r\n" + header + "\r\n"
+	if _, err = conn.Write([]byte(req)); err != nil {
+		t.Fatalf("write handshake: %s", err)
+	}
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil {
+		t.Fatalf("read handshake: %s", err)
+	}
+	return conn, br, resp
+}
+
+func wsClientFrame(fin bool, rsv1 bool, opcode int, payload []byte) []byte {
+	b0 := byte(opcode)
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+	buf := []byte{b0}
+	switch l := len(payload); {
+	case l <= 125:
+		buf = append(buf, 0x80|byte(l))
+	default:
+		buf = append(buf, 0x80|126, byte(l>>8), byte(l))
+	}
+	mask := []byte{1, 2, 3, 4}
+	buf = append(buf, mask...)
+	for i, b := range payload {
+		buf = append(buf, b^mask[i&3])
+	}
+	return buf
+}
+
+func wsReadServerFrame(t *testing.T, br *bufio.Reader) (bool, bool, int, []byte) {
+	var h [2]byte
+	if _, err := io.ReadFull(br, h[:]); err != nil {
+		t.Fatalf("read frame: %s", err)
+	}
+	l := int(h[1] & 0x7f)
+	if l == 126 {
+		var ext [2]byte
+		io.ReadFull(br, ext[:])
+		l = int(binary.BigEndian.Uint16(ext[:]))
+	}
+	p := make([]byte, l)
+	io.ReadFull(br, p)
+	return h[0]&0x80 != 0, h[0]&0x40 != 0, int(h[0] & 0x0f), p
+}
+
+func TestWebSocketEcho(t *testing.T) {
+	ln, stop := wsServe(t, WebSocket(func(conn *WSConn) {
+		for {
+			mt, msg, err := conn.ReadMessage()
+			if err != nil {
+				return
+			}
+			conn.WriteMessage(mt, msg)
+		}
+	}, WebSocketConfig{Subprotocols: []string{"chat"}}))
+	defer stop()
+
+	conn, br, resp := wsDial(t, ln, "Sec-WebSocket-Protocol: superchat, chat\r\n")
+	defer conn.Close()
+	if resp.StatusCode != StatusSwitchingProtocols {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Accept"); got != "s3pPLMBiTxaQ9kYGzzhZRbK+xOo=" {
+		t.Fatalf("unexpected accept key %q", got)
+	}
+	if got := resp.Header.Get("Sec-WebSocket-Protocol"); got != "chat" {
+		t.Fatalf("unexpected subprotocol %q", got)
+	}
+
+	// fragmented text message with a ping in between
+	conn.Write(wsClientFrame(false, false, TextMessage, []byte("hello ")))
+	conn.Write(wsClientFrame(true, false, PingMessage, []byte("p")))
+	conn.Write(wsClientFrame(true, false, continuationFrame, []byte("world")))
+
+	if _, _, op, p := wsReadServerFrame(t, br); op != PongMessage || string(p) != "p" {
+		t.Fatalf("expected pong, got opcode %d %q", op, p)
+	}
+	if fin, _, op, p := wsReadServerFrame(t, br); !fin || op != TextMessage || string(p) != "hello world" {
+		t.Fatalf("unexpected echo fin=%v opcode=%d %q", fin, op, p)
+	}
+
+	conn.Write(wsClientFrame(true, false, CloseMessage, FormatCloseMessage(CloseGoingAway, "bye")))
+	if _, _, op, p := wsReadServerFrame(t, br); op != CloseMessage || binary.BigEndian.Uint16(p) != CloseGoingAway {
+		t.Fatalf("expected close echo, got opcode %d %v", op, p)
+	}
+}
+
+func TestWebSocketCompression(t *testing.T) {
+	ln, stop := wsServe(t, WebSocket(func(conn *WSConn) {
+		_, msg, err := conn.ReadMessage()
+		if err != nil {
+			return
+		}
+		conn.WriteMessage(TextMessage, msg)
+	}, WebSocketConfig{EnableCompression: true, CompressionThreshold: 1}))
+	defer stop()
+
+	conn, br, resp := wsDial(t, ln, "Sec-WebSocket-Extensions: permessage-deflate; client_max_window_bits\r\n")
+	defer conn.Close()
+	if !strings.HasPrefix(resp.Header.Get("Sec-WebSocket-Extensions"), "permessage-deflate") {
+		t.Fatalf("permessage-deflate not negotiated: %q", resp.Header.Get("Sec-WebSocket-Extensions"))
+	}
+
+	msg := strings.Repeat("compress me ", 50)
+	var buf bytes.Buffer
+	fw, _ := flate.NewWriter(&buf, flate.BestCompression)
+	fw.Write([]byte(msg))
+	fw.Flush()
+	conn.Write(wsClientFrame(true, true, TextMessage, bytes.TrimSuffix(buf.Bytes(), []byte{0, 0, 0xff, 0xff})))
+
+	_, rsv1, op, p := wsReadServerFrame(t, br)
+	if !rsv1 || op != TextMessage {
+		t.Fatalf("expected compressed text frame, got rsv1=%v opcode=%d", rsv1, op)
+	}
+	out, err := io.ReadAll(flate.NewReader(io.MultiReader(bytes.NewReader(p), bytes.NewReader(wsDeflateTail))))
+	if err != nil || string(out) != msg {
+		t.Fatalf("unexpected payload %q err %v", out, err)
+	}
+}
+
+func TestWebSocketOrigin(t *testing.T) {
+	ln, stop := wsServe(t, WebSocket(func(conn *WSConn) {}, WebSocketConfig{Origins: []string{"https://*.example.com"}}))
+	defer stop()
+
+	conn, _, resp := wsDial(t, ln, "Origin: https://evil.com\r\n")
+	conn.Close()
+	if resp.StatusCode != StatusForbidden {
+		t.Fatalf("expected %d, got %d", StatusForbidden, resp.StatusCode)
+	}
+
+	conn, _, resp = wsDial(t, ln, "Origin: https://app.example.com\r\n")
+	conn.SetDeadline(time.Now().Add(time.Second))
+	conn.Close()
+	if resp.StatusCode != StatusSwitchingProtocols {
+		t.Fatalf("expected %d, got %d", StatusSwitchingProtocols, resp.StatusCode)
+	}
+}